@@ -0,0 +1,146 @@
+// Package cli implements the mongeta command-line client, which talks to a
+// running manager over its HTTP API:
+//
+//	mongeta job plan <job-id> <spec.yaml>
+//	mongeta job apply [-check-index N] <job-id> <spec.yaml>
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ctfrancia/mongeta/manager"
+	"github.com/google/uuid"
+)
+
+const usage = `usage:
+  mongeta job plan <job-id> <spec.yaml>
+  mongeta job apply [-check-index N] <job-id> <spec.yaml>`
+
+// Run executes the command in args against the manager at addr.
+func Run(addr string, args []string, out io.Writer) error {
+	c := client{base: "http://" + addr, http: &http.Client{Timeout: 30 * time.Second}}
+	if len(args) < 2 || args[0] != "job" {
+		return errors.New(usage)
+	}
+	switch args[1] {
+	case "plan":
+		return c.plan(args[2:], out)
+	case "apply":
+		return c.apply(args[2:], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[1], usage)
+	}
+}
+
+type client struct {
+	base string
+	http *http.Client
+}
+
+func (c client) plan(args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errors.New(usage)
+	}
+	p := manager.JobPlan{}
+	if err := c.sendSpec(http.MethodPost, "/jobs/"+args[0]+"/plan", args[1], &p); err != nil {
+		return err
+	}
+
+	if len(p.Diff) == 0 {
+		fmt.Fprintln(out, "No changes.")
+		return nil
+	}
+	fmt.Fprintf(out, "Job %s, version %d:\n", p.JobID, p.Version)
+	for _, d := range p.Diff {
+		switch d.Type {
+		case manager.DiffAdded:
+			fmt.Fprintf(out, "  + %s: %q\n", d.Field, d.New)
+		case manager.DiffRemoved:
+			fmt.Fprintf(out, "  - %s: %q\n", d.Field, d.Old)
+		default:
+			fmt.Fprintf(out, "  ~ %s: %q => %q\n", d.Field, d.Old, d.New)
+		}
+	}
+	fmt.Fprintln(out)
+	for _, t := range p.Tasks {
+		if t.TaskID == uuid.Nil {
+			fmt.Fprintf(out, "  %-8s (new task)\n", t.Action)
+			continue
+		}
+		fmt.Fprintf(out, "  %-8s %s %s\n", t.Action, t.TaskID, t.Name)
+	}
+	fmt.Fprintf(out, "\n%d to create, %d to update in place, %d to replace, %d to destroy.\n",
+		p.Summary[manager.PlanCreate], p.Summary[manager.PlanUpdate],
+		p.Summary[manager.PlanReplace], p.Summary[manager.PlanDestroy])
+	if p.Deployment {
+		fmt.Fprintln(out, "The template changes, so tasks are replaced by a deployment.")
+	}
+	fmt.Fprintf(out, "\nTo apply exactly this plan, run:\n  mongeta job apply -check-index %d %s %s\n", p.CheckIndex, args[0], args[1])
+	return nil
+}
+
+func (c client) apply(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	checkIndex := fs.Uint64("check-index", 0, "refuse the change if the job changed since this plan index")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%v\n%s", err, usage)
+	}
+	if fs.NArg() != 2 {
+		return errors.New(usage)
+	}
+
+	path := "/jobs/" + fs.Arg(0)
+	checked := false
+	fs.Visit(func(f *flag.Flag) { checked = checked || f.Name == "check-index" })
+	if checked {
+		path += "?" + url.Values{"check_index": {strconv.FormatUint(*checkIndex, 10)}}.Encode()
+	}
+
+	j := manager.Job{}
+	if err := c.sendSpec(http.MethodPut, path, fs.Arg(1), &j); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Job %s is now at version %d.\n", j.ID, j.Version)
+	return nil
+}
+
+// sendSpec sends the YAML spec in file to the manager and decodes the
+// response into v.
+func (c client) sendSpec(method, path, file string, v any) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest(method, c.base+path, f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to manager: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := manager.ErrResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+			return fmt.Errorf("manager returned %s", resp.Status)
+		}
+		return errors.New(e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
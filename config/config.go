@@ -15,6 +15,14 @@ type WorkerConfig struct {
 	RunInterval    time.Duration `env:"MONGETA_WORKER_RUN_INTERVAL" envDefault:"10s"`
 	StatsInterval  time.Duration `env:"MONGETA_WORKER_STATS_INTERVAL" envDefault:"15s"`
 	UpdateInterval time.Duration `env:"MONGETA_WORKER_UPDATE_INTERVAL" envDefault:"15s"`
+	// TaskDir holds the secret files and rendered templates of tasks. It must
+	// be on a tmpfs; the worker will not start otherwise.
+	TaskDir string `env:"MONGETA_WORKER_TASK_DIR" envDefault:"/dev/shm/mongeta"`
+	// MinPort and MaxPort bound the host ports allocated to tasks.
+	MinPort int `env:"MONGETA_WORKER_MIN_PORT" envDefault:"20000"`
+	MaxPort int `env:"MONGETA_WORKER_MAX_PORT" envDefault:"32000"`
+	// Labels describe the node to task templates, as "key:value,...".
+	Labels map[string]string `env:"MONGETA_WORKER_LABELS"`
 }
 
 type ManagerConfig struct {
@@ -25,6 +33,20 @@ type ManagerConfig struct {
 	UpdateInterval      time.Duration `env:"MONGETA_MANAGER_UPDATE_INTERVAL" envDefault:"15s"`
 	MaxRestarts         int           `env:"MONGETA_MANAGER_MAX_RESTARTS" envDefault:"3"`
 	HealthCheckInterval time.Duration `env:"MONGETA_MANAGER_HEALTH_INTERVAL" envDefault:"20s"`
+	ReconcileInterval   time.Duration `env:"MONGETA_MANAGER_RECONCILE_INTERVAL" envDefault:"10s"`
+	PeriodicInterval    time.Duration `env:"MONGETA_MANAGER_PERIODIC_INTERVAL" envDefault:"5s"`
+	// SecretsKey is the base64 AES-256 key secrets are encrypted with. Without
+	// one a random key is used and secrets do not survive a restart.
+	SecretsKey  string `env:"MONGETA_MANAGER_SECRETS_KEY"`
+	SecretsFile string `env:"MONGETA_MANAGER_SECRETS_FILE"`
+	// IdempotencyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for retries.
+	IdempotencyTTL time.Duration `env:"MONGETA_MANAGER_IDEMPOTENCY_TTL" envDefault:"24h"`
+	// OverflowSize is how many task events may wait once the queue is
+	// full before submissions are rejected; 0 disables the overflow
+	// queue. With OverflowFile set, they survive a restart.
+	OverflowSize int    `env:"MONGETA_MANAGER_OVERFLOW_SIZE" envDefault:"0"`
+	OverflowFile string `env:"MONGETA_MANAGER_OVERFLOW_FILE"`
 }
 
 type ServerConfig struct {
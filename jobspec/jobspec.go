@@ -0,0 +1,479 @@
+// Package jobspec parses the human-friendly YAML job specification into the
+// types the manager works with. A spec looks like:
+//
+//	name: web
+//	type: service
+//	replicas: 3
+//	task:
+//	  image: strm/helloworld-http
+//	  cpu: 0.5 cpu
+//	  memory: 512Mi
+//	  ports: ["http=80/tcp"]
+//	  health_check: /health
+//
+// IDs and states are never part of a spec; the manager assigns them.
+package jobspec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/docker/go-connections/nat"
+	"github.com/moby/moby/api/types/container"
+	"gopkg.in/yaml.v3"
+)
+
+// Job types a spec may declare.
+const (
+	TypeTask    = "task"
+	TypeService = "service"
+	TypeBatch   = "batch"
+)
+
+// Spec is a parsed job specification. Type defaults to "task", a single
+// task run once.
+type Spec struct {
+	Name         string    `yaml:"name"`
+	Type         string    `yaml:"type"`
+	Replicas     *int      `yaml:"replicas"`
+	Completions  int       `yaml:"completions"`
+	Parallelism  int       `yaml:"parallelism"`
+	BackoffLimit int       `yaml:"backoff_limit"`
+	Periodic     *Periodic `yaml:"periodic"`
+	Update       *Update   `yaml:"update"`
+	Task         TaskSpec  `yaml:"task"`
+}
+
+// TaskSpec describes the container every task of the job runs.
+type TaskSpec struct {
+	Image         string   `yaml:"image"`
+	CPU           CPU      `yaml:"cpu"`
+	Memory        Bytes    `yaml:"memory"`
+	Disk          Bytes    `yaml:"disk"`
+	Ports         []string `yaml:"ports"`
+	RestartPolicy string   `yaml:"restart_policy"`
+	HealthCheck   string   `yaml:"health_check"`
+	// MaxRunDuration kills the task once it has run this long.
+	// ScheduleDeadline fails it if it cannot be placed this soon.
+	MaxRunDuration   Duration `yaml:"max_run_duration"`
+	ScheduleDeadline Duration `yaml:"schedule_deadline"`
+	// StopSignal and StopTimeout control how the task is stopped: the
+	// signal is sent first and the container killed after the timeout.
+	StopSignal  string   `yaml:"stop_signal"`
+	StopTimeout Duration `yaml:"stop_timeout"`
+	// InitContainers run in order to completion before the task starts;
+	// Sidecars run beside it in its network namespace.
+	InitContainers []ContainerSpec `yaml:"init_containers"`
+	Sidecars       []ContainerSpec `yaml:"sidecars"`
+	// Secrets are injected from the manager's secret store at start.
+	Secrets []SecretSpec `yaml:"secrets"`
+	// Templates are config files rendered into the task's container.
+	Templates []TemplateSpec `yaml:"templates"`
+	// Labels are matched by selectors; annotations are free-form.
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// SecretSpec injects a stored secret into the task as the environment
+// variable Env or as a read-only file at the path File.
+type SecretSpec struct {
+	Name string `yaml:"name"`
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+}
+
+// ContainerSpec describes an init container or sidecar of a task.
+type ContainerSpec struct {
+	Name    string            `yaml:"name"`
+	Image   string            `yaml:"image"`
+	Command []string          `yaml:"command"`
+	Env     map[string]string `yaml:"env"`
+	CPU     CPU               `yaml:"cpu"`
+	Memory  Bytes             `yaml:"memory"`
+}
+
+// TemplateSpec is a config file rendered with text/template on the worker
+// and mounted read-only at Destination. ChangeMode, one of noop, restart or
+// signal, says what happens to the task when the secrets or keys it reads
+// change.
+type TemplateSpec struct {
+	Data         string `yaml:"data"`
+	Destination  string `yaml:"destination"`
+	ChangeMode   string `yaml:"change_mode"`
+	ChangeSignal string `yaml:"change_signal"`
+}
+
+// Periodic is the cron schedule of a periodic batch job.
+type Periodic struct {
+	Schedule               string   `yaml:"schedule"`
+	TimeZone               string   `yaml:"time_zone"`
+	OverlapPolicy          string   `yaml:"overlap_policy"`
+	StartingDeadline       Duration `yaml:"starting_deadline"`
+	SuccessfulHistoryLimit int      `yaml:"successful_history_limit"`
+	FailedHistoryLimit     int      `yaml:"failed_history_limit"`
+}
+
+// Update is the update strategy of a service.
+type Update struct {
+	Type            string   `yaml:"type"`
+	Canary          int      `yaml:"canary"`
+	MaxParallel     int      `yaml:"max_parallel"`
+	MaxSurge        int      `yaml:"max_surge"`
+	MinHealthyTime  Duration `yaml:"min_healthy_time"`
+	HealthyDeadline Duration `yaml:"healthy_deadline"`
+	AutoRevert      bool     `yaml:"auto_revert"`
+}
+
+// FieldError is a problem with one field of a spec. Field is the path of
+// the field as written in YAML, such as "task.ports[1]".
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// FieldErrors collects every problem found while validating a spec.
+type FieldErrors []FieldError
+
+func (fe FieldErrors) Error() string {
+	msgs := make([]string, len(fe))
+	for i, e := range fe {
+		msgs[i] = e.Error()
+	}
+	return "invalid job spec: " + strings.Join(msgs, "; ")
+}
+
+func (fe *FieldErrors) add(field, format string, args ...any) {
+	*fe = append(*fe, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Parse decodes a YAML spec, applies defaults and validates it. Unknown
+// fields are rejected. Validation problems are returned as FieldErrors.
+func Parse(r io.Reader) (*Spec, error) {
+	d := yaml.NewDecoder(r)
+	d.KnownFields(true)
+
+	s := &Spec{}
+	if err := d.Decode(s); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty job spec")
+		}
+		return nil, fmt.Errorf("error parsing job spec: %w", err)
+	}
+
+	s.setDefaults()
+	if errs := s.Validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return s, nil
+}
+
+func (s *Spec) setDefaults() {
+	if s.Type == "" {
+		s.Type = TypeTask
+	}
+	if s.Type == TypeService && s.Replicas == nil {
+		one := 1
+		s.Replicas = &one
+	}
+}
+
+var portLabelRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+var stopSignalRe = regexp.MustCompile(`^(SIG[A-Z0-9+-]+|[0-9]+)$`)
+
+var restartPolicies = []container.RestartPolicyMode{
+	container.RestartPolicyDisabled,
+	container.RestartPolicyAlways,
+	container.RestartPolicyOnFailure,
+	container.RestartPolicyUnlessStopped,
+}
+
+// Validate checks the spec and returns every problem found.
+func (s *Spec) Validate() FieldErrors {
+	var errs FieldErrors
+
+	if strings.TrimSpace(s.Name) == "" {
+		errs.add("name", "is required")
+	}
+	switch s.Type {
+	case TypeTask, TypeService, TypeBatch:
+	default:
+		errs.add("type", "must be one of task, service or batch, got %q", s.Type)
+	}
+
+	if s.Replicas != nil {
+		if s.Type != TypeService {
+			errs.add("replicas", "is only valid for services")
+		} else if *s.Replicas < 0 {
+			errs.add("replicas", "must not be negative")
+		}
+	}
+	if s.Type != TypeBatch {
+		if s.Completions != 0 || s.Parallelism != 0 || s.BackoffLimit != 0 {
+			errs.add("completions", "completions, parallelism and backoff_limit are only valid for batch jobs")
+		}
+		if s.Periodic != nil {
+			errs.add("periodic", "is only valid for batch jobs")
+		}
+	}
+	if s.Completions < 0 {
+		errs.add("completions", "must not be negative")
+	}
+	if s.Parallelism < 0 {
+		errs.add("parallelism", "must not be negative")
+	}
+	if s.BackoffLimit < 0 {
+		errs.add("backoff_limit", "must not be negative")
+	}
+	if s.Periodic != nil && strings.TrimSpace(s.Periodic.Schedule) == "" {
+		errs.add("periodic.schedule", "is required")
+	}
+	if s.Update != nil {
+		if s.Type != TypeService {
+			errs.add("update", "is only valid for services")
+		}
+		if s.Update.Canary < 0 {
+			errs.add("update.canary", "must not be negative")
+		}
+		if s.Update.MaxParallel < 0 {
+			errs.add("update.max_parallel", "must not be negative")
+		}
+		if s.Update.MaxSurge < 0 {
+			errs.add("update.max_surge", "must not be negative")
+		}
+	}
+
+	s.Task.validate("task", &errs)
+	return errs
+}
+
+func (ts *TaskSpec) validate(prefix string, errs *FieldErrors) {
+	if strings.TrimSpace(ts.Image) == "" {
+		errs.add(prefix+".image", "is required")
+	} else if err := validImage(ts.Image); err != nil {
+		errs.add(prefix+".image", "invalid image reference %q: %v", ts.Image, err)
+	}
+	validateResources(float64(ts.CPU), int64(ts.Memory), int64(ts.Disk), specField(prefix), errs)
+	labels := make(map[string]bool)
+	for i, p := range ts.Ports {
+		field := fmt.Sprintf("%s.ports[%d]", prefix, i)
+		label, _, err := parseLabelledPort(p)
+		switch {
+		case err != nil:
+			errs.add(field, "%v", err)
+		case label != "" && labels[label]:
+			errs.add(field, "label %q is used by another port", label)
+		}
+		labels[label] = label != ""
+	}
+	if ts.MaxRunDuration < 0 {
+		errs.add(prefix+".max_run_duration", "must not be negative")
+	}
+	if ts.ScheduleDeadline < 0 {
+		errs.add(prefix+".schedule_deadline", "must not be negative")
+	}
+	if ts.StopSignal != "" && !stopSignalRe.MatchString(ts.StopSignal) {
+		errs.add(prefix+".stop_signal", "must be a signal name such as SIGINT or a number, got %q", ts.StopSignal)
+	}
+	if ts.StopTimeout < 0 {
+		errs.add(prefix+".stop_timeout", "must not be negative")
+	}
+	names := make(map[string]bool)
+	for i, c := range ts.InitContainers {
+		c.validate(fmt.Sprintf("%s.init_containers[%d]", prefix, i), names, errs)
+	}
+	for i, c := range ts.Sidecars {
+		c.validate(fmt.Sprintf("%s.sidecars[%d]", prefix, i), names, errs)
+	}
+	for i, sec := range ts.Secrets {
+		field := fmt.Sprintf("%s.secrets[%d]", prefix, i)
+		if strings.TrimSpace(sec.Name) == "" {
+			errs.add(field+".name", "is required")
+		}
+		switch {
+		case (sec.Env == "") == (sec.File == ""):
+			errs.add(field, "needs exactly one of env or file")
+		case sec.File != "" && (!path.IsAbs(sec.File) || path.Clean(sec.File) != sec.File):
+			errs.add(field+".file", "must be a clean absolute path, got %q", sec.File)
+		}
+	}
+	destinations := make(map[string]bool)
+	for i, tpl := range ts.Templates {
+		tpl.validate(fmt.Sprintf("%s.templates[%d]", prefix, i), destinations, errs)
+	}
+	for _, k := range slices.Sorted(maps.Keys(ts.Labels)) {
+		field := fmt.Sprintf("%s.labels.%s", prefix, k)
+		if !task.ValidLabelKey(k) {
+			errs.add(field, "invalid label key")
+		} else if !task.ValidLabelValue(ts.Labels[k]) {
+			errs.add(field, "invalid label value %q", ts.Labels[k])
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(ts.Annotations)) {
+		if !task.ValidLabelKey(k) {
+			errs.add(fmt.Sprintf("%s.annotations.%s", prefix, k), "invalid annotation key")
+		}
+	}
+	if ts.RestartPolicy != "" && !slices.Contains(restartPolicies, container.RestartPolicyMode(ts.RestartPolicy)) {
+		errs.add(prefix+".restart_policy", "must be one of no, always, on-failure or unless-stopped, got %q", ts.RestartPolicy)
+	}
+}
+
+func (cs *ContainerSpec) validate(prefix string, names map[string]bool, errs *FieldErrors) {
+	switch {
+	case strings.TrimSpace(cs.Name) == "":
+		errs.add(prefix+".name", "is required")
+	case names[cs.Name]:
+		errs.add(prefix+".name", "%q is used by another container of the task", cs.Name)
+	}
+	names[cs.Name] = true
+	if strings.TrimSpace(cs.Image) == "" {
+		errs.add(prefix+".image", "is required")
+	} else if err := validImage(cs.Image); err != nil {
+		errs.add(prefix+".image", "invalid image reference %q: %v", cs.Image, err)
+	}
+	validateResources(float64(cs.CPU), int64(cs.Memory), 0, specField(prefix), errs)
+}
+
+// specField names a field of a spec in YAML's spelling, such as
+// "task.memory".
+func specField(prefix string) func(name string) string {
+	return func(name string) string { return prefix + "." + strings.ToLower(name) }
+}
+
+func (tpl *TemplateSpec) validate(prefix string, destinations map[string]bool, errs *FieldErrors) {
+	switch {
+	case !path.IsAbs(tpl.Destination) || path.Clean(tpl.Destination) != tpl.Destination:
+		errs.add(prefix+".destination", "must be a clean absolute path, got %q", tpl.Destination)
+	case destinations[tpl.Destination]:
+		errs.add(prefix+".destination", "%q is used by another template", tpl.Destination)
+	}
+	destinations[tpl.Destination] = true
+	switch task.ChangeMode(tpl.ChangeMode) {
+	case "", task.ChangeModeNoop, task.ChangeModeRestart:
+	case task.ChangeModeSignal:
+		if !stopSignalRe.MatchString(tpl.ChangeSignal) {
+			errs.add(prefix+".change_signal", "must be a signal name such as SIGHUP or a number, got %q", tpl.ChangeSignal)
+		}
+	default:
+		errs.add(prefix+".change_mode", "must be one of noop, restart or signal, got %q", tpl.ChangeMode)
+	}
+	if _, _, err := task.TemplateRefs(task.Task{Templates: []task.Template{tpl.toTemplate()}}); err != nil {
+		errs.add(prefix+".data", "%v", err)
+	}
+}
+
+func (tpl *TemplateSpec) toTemplate() task.Template {
+	return task.Template{
+		Data:         tpl.Data,
+		Destination:  tpl.Destination,
+		ChangeMode:   task.ChangeMode(tpl.ChangeMode),
+		ChangeSignal: tpl.ChangeSignal,
+	}
+}
+
+// parseLabelledPort parses a port with an optional label, "http=8080/tcp".
+// The label names the port's MONGETA_PORT_<label> variable in the task.
+func parseLabelledPort(s string) (string, nat.Port, error) {
+	label, port, ok := strings.Cut(s, "=")
+	if !ok {
+		port, label = label, ""
+	} else if !portLabelRe.MatchString(label) {
+		return "", "", fmt.Errorf("invalid port label %q", label)
+	}
+	p, err := parsePort(port)
+	return label, p, err
+}
+
+// parsePort parses "8080" or "8080/tcp" into a container port.
+func parsePort(s string) (nat.Port, error) {
+	proto, port := nat.SplitProtoPort(s)
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", s)
+	}
+	switch proto {
+	case "tcp", "udp", "sctp":
+	default:
+		return "", fmt.Errorf("invalid protocol %q in port %q", proto, s)
+	}
+	return nat.NewPort(proto, port)
+}
+
+// ToTask builds the task every instance of the job runs. It carries no ID or
+// state; the manager assigns those on submission.
+func (s *Spec) ToTask() task.Task {
+	t := task.Task{
+		Name:          s.Name,
+		Image:         s.Task.Image,
+		CPU:           float64(s.Task.CPU),
+		Memory:        int64(s.Task.Memory),
+		Disk:          int64(s.Task.Disk),
+		RestartPolicy: container.RestartPolicyMode(s.Task.RestartPolicy),
+		HealthCheck:   s.Task.HealthCheck,
+
+		MaxRunDuration:   time.Duration(s.Task.MaxRunDuration),
+		ScheduleDeadline: time.Duration(s.Task.ScheduleDeadline),
+		StopSignal:       s.Task.StopSignal,
+		StopTimeout:      time.Duration(s.Task.StopTimeout),
+
+		Labels:      maps.Clone(s.Task.Labels),
+		Annotations: maps.Clone(s.Task.Annotations),
+	}
+	for _, c := range s.Task.InitContainers {
+		t.InitContainers = append(t.InitContainers, c.toContainer())
+	}
+	for _, c := range s.Task.Sidecars {
+		t.Sidecars = append(t.Sidecars, c.toContainer())
+	}
+	for _, tpl := range s.Task.Templates {
+		t.Templates = append(t.Templates, tpl.toTemplate())
+	}
+	for _, sec := range s.Task.Secrets {
+		t.Secrets = append(t.Secrets, task.SecretRef{Name: sec.Name, Env: sec.Env, File: sec.File})
+	}
+	if len(s.Task.Ports) > 0 {
+		t.ExposedPorts = make(nat.PortSet, len(s.Task.Ports))
+		for _, p := range s.Task.Ports {
+			label, port, err := parseLabelledPort(p)
+			if err != nil {
+				continue
+			}
+			t.ExposedPorts[port] = struct{}{}
+			if label != "" {
+				if t.PortLabels == nil {
+					t.PortLabels = make(map[string]nat.Port)
+				}
+				t.PortLabels[label] = port
+			}
+		}
+	}
+	return t
+}
+
+func (cs *ContainerSpec) toContainer() task.Container {
+	c := task.Container{
+		Name:   cs.Name,
+		Image:  cs.Image,
+		Cmd:    cs.Command,
+		CPU:    float64(cs.CPU),
+		Memory: int64(cs.Memory),
+	}
+	for _, k := range slices.Sorted(maps.Keys(cs.Env)) {
+		c.Env = append(c.Env, k+"="+cs.Env[k])
+	}
+	return c
+}
@@ -0,0 +1,204 @@
+package jobspec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"1048576", 1048576},
+		{"512Mi", 512 << 20},
+		{"1Gi", 1 << 30},
+		{"1.5G", 1500000000},
+		{"256MB", 256000000},
+		{"64 Ki", 64 << 10},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.input)
+		if err != nil {
+			t.Fatalf("ParseBytes(%q): unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "Mi", "12 parsecs", "-1Gi"} {
+		if _, err := ParseBytes(bad); err == nil {
+			t.Errorf("ParseBytes(%q): expected error, got nil", bad)
+		}
+	}
+}
+
+func TestParseCPU(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"0.5", 0.5},
+		{"0.5 cpu", 0.5},
+		{"2 cpus", 2},
+		{"250m", 0.25},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPU(tt.input)
+		if err != nil {
+			t.Fatalf("ParseCPU(%q): unexpected error: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseCPU(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := ParseCPU("1 gpu"); err == nil {
+		t.Error(`ParseCPU("1 gpu"): expected error, got nil`)
+	}
+}
+
+func TestParseService(t *testing.T) {
+	spec, err := Parse(strings.NewReader(`
+name: web
+type: service
+replicas: 3
+update:
+  type: canary
+  canary: 1
+  min_healthy_time: 30s
+task:
+  image: strm/helloworld-http
+  cpu: 0.5 cpu
+  memory: 512Mi
+  ports: ["80/tcp", "9090"]
+  stop_signal: SIGQUIT
+  stop_timeout: 45s
+`))
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if *spec.Replicas != 3 {
+		t.Errorf("Replicas = %d, want 3", *spec.Replicas)
+	}
+	if time.Duration(spec.Update.MinHealthyTime) != 30*time.Second {
+		t.Errorf("MinHealthyTime = %v, want 30s", time.Duration(spec.Update.MinHealthyTime))
+	}
+
+	tk := spec.ToTask()
+	if tk.Memory != 512<<20 || tk.CPU != 0.5 {
+		t.Errorf("task resources = %d bytes %v cpu, want 512Mi and 0.5", tk.Memory, tk.CPU)
+	}
+	if tk.StopSignal != "SIGQUIT" || tk.StopTimeout != 45*time.Second {
+		t.Errorf("stop = %q after %v, want SIGQUIT after 45s", tk.StopSignal, tk.StopTimeout)
+	}
+	for _, p := range []nat.Port{"80/tcp", "9090/tcp"} {
+		if _, ok := tk.ExposedPorts[p]; !ok {
+			t.Errorf("ExposedPorts missing %s", p)
+		}
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	spec, err := Parse(strings.NewReader("name: once\ntask:\n  image: busybox\n"))
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if spec.Type != TypeTask {
+		t.Errorf("Type = %q, want %q", spec.Type, TypeTask)
+	}
+
+	spec, err = Parse(strings.NewReader("name: web\ntype: service\ntask:\n  image: nginx\n"))
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if spec.Replicas == nil || *spec.Replicas != 1 {
+		t.Errorf("Replicas = %v, want 1", spec.Replicas)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse(strings.NewReader(`
+type: cron
+replicas: -1
+task:
+  memory: 1Mi
+  ports: ["http", "70000/tcp"]
+  restart_policy: sometimes
+  stop_signal: quit
+`))
+	var fe FieldErrors
+	if !errors.As(err, &fe) {
+		t.Fatalf("Parse error = %v, want FieldErrors", err)
+	}
+
+	want := []string{"name", "type", "replicas", "task.image", "task.memory", "task.ports[0]", "task.ports[1]", "task.restart_policy", "task.stop_signal"}
+	got := make(map[string]bool)
+	for _, e := range fe {
+		got[e.Field] = true
+	}
+	for _, field := range want {
+		if !got[field] {
+			t.Errorf("missing error for %s in %v", field, fe)
+		}
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	_, err := Parse(strings.NewReader("name: web\nimage: nginx\n"))
+	if err == nil {
+		t.Fatal("expected error for unknown field, got nil")
+	}
+	var fe FieldErrors
+	if errors.As(err, &fe) {
+		t.Errorf("unknown field reported as validation error: %v", err)
+	}
+}
+
+func TestParseTaskGroup(t *testing.T) {
+	spec, err := Parse(strings.NewReader(`
+name: web
+type: service
+task:
+  image: nginx
+  init_containers:
+    - name: migrate
+      image: migrate/migrate
+      command: ["up"]
+  sidecars:
+    - name: logs
+      image: fluent/fluent-bit
+      env: {LEVEL: info, OUTPUT: stdout}
+      memory: 64Mi
+`))
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	tk := spec.ToTask()
+	if len(tk.InitContainers) != 1 || tk.InitContainers[0].Cmd[0] != "up" {
+		t.Errorf("InitContainers = %+v, want migrate running up", tk.InitContainers)
+	}
+	if len(tk.Sidecars) != 1 || tk.Sidecars[0].Memory != 64<<20 {
+		t.Fatalf("Sidecars = %+v, want logs with 64Mi", tk.Sidecars)
+	}
+	if env := tk.Sidecars[0].Env; len(env) != 2 || env[0] != "LEVEL=info" || env[1] != "OUTPUT=stdout" {
+		t.Errorf("sidecar Env = %v, want sorted KEY=value pairs", env)
+	}
+
+	_, err = Parse(strings.NewReader(`
+name: web
+task:
+  image: nginx
+  init_containers: [{name: setup}]
+  sidecars: [{name: setup, image: busybox}]
+`))
+	var fe FieldErrors
+	if !errors.As(err, &fe) || len(fe) != 2 {
+		t.Fatalf("Parse error = %v, want missing image and duplicate name", err)
+	}
+}
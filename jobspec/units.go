@@ -0,0 +1,115 @@
+package jobspec
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var quantityRe = regexp.MustCompile(`^([0-9]*\.?[0-9]+)\s*([A-Za-z]*)$`)
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"t":   1e12,
+	"tb":  1e12,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseBytes parses a size such as "512Mi", "1.5G", "256MB" or "1048576".
+// Binary suffixes (Ki, Mi, Gi, Ti) are powers of 1024 and decimal ones (K,
+// M, G, T) powers of 1000; a bare number is a count of bytes.
+func ParseBytes(s string) (int64, error) {
+	m := quantityRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	mult, ok := byteUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, m[2])
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	v := n * mult
+	if v > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int64(v), nil
+}
+
+// ParseCPU parses a CPU amount in cores, such as "0.5", "0.5 cpu", "2 cpus"
+// or "500m" (millicores).
+func ParseCPU(s string) (float64, error) {
+	m := quantityRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid cpu %q", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu %q: %v", s, err)
+	}
+	switch strings.ToLower(m[2]) {
+	case "", "cpu", "cpus", "core", "cores":
+		return n, nil
+	case "m":
+		return n / 1000, nil
+	default:
+		return 0, fmt.Errorf("invalid cpu %q: unknown unit %q", s, m[2])
+	}
+}
+
+// Bytes is a size that may be written with a unit suffix in YAML.
+type Bytes int64
+
+func (b *Bytes) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseBytes(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*b = Bytes(v)
+	return nil
+}
+
+// CPU is an amount of CPU cores that may be written with a unit in YAML.
+type CPU float64
+
+func (c *CPU) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseCPU(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*c = CPU(v)
+	return nil
+}
+
+// Duration is a time.Duration written as "10s", "5m" or "1h30m" in YAML.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, node.Value)
+	}
+	*d = Duration(v)
+	return nil
+}
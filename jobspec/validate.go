@@ -0,0 +1,154 @@
+package jobspec
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/distribution/reference"
+	"github.com/google/uuid"
+)
+
+// minMemory is the least memory Docker lets a container be limited to.
+const minMemory = 6 * 1024 * 1024
+
+// validImage checks that image is a valid image reference, such as
+// "nginx", "strm/helloworld-http:latest" or one with a registry and digest.
+func validImage(image string) error {
+	_, err := reference.ParseNormalizedNamed(image)
+	return err
+}
+
+// validateResources checks the CPU, memory and disk limits of a task or one
+// of its containers, for specs and JSON submissions alike. field spells the
+// name of each as the caller's format does.
+func validateResources(cpu float64, memory, disk int64, field func(name string) string, errs *FieldErrors) {
+	if cpu < 0 {
+		errs.add(field("CPU"), "must not be negative")
+	}
+	if memory < 0 || (memory > 0 && memory < minMemory) {
+		errs.add(field("Memory"), "must be 0 for no limit or at least 6Mi, got %d", memory)
+	}
+	if disk < 0 {
+		errs.add(field("Disk"), "must not be negative")
+	}
+}
+
+// ValidateTaskEvent checks a task event submitted directly to the manager,
+// rather than as a spec. Fields are named as in the JSON body, such as
+// "Task.Memory".
+func ValidateTaskEvent(te task.TaskEvent) FieldErrors {
+	var errs FieldErrors
+	if te.ID == uuid.Nil {
+		errs.add("ID", "is required")
+	}
+	if te.State != task.Scheduled {
+		errs.add("State", "must be Scheduled for a new task, got %s", te.State)
+	}
+	ValidateTask("Task", te.Task, &errs)
+	return errs
+}
+
+// validateContainer checks one init container or sidecar of a submitted
+// task. names holds the names of the task's containers checked so far.
+func validateContainer(prefix string, c task.Container, names map[string]bool, errs *FieldErrors) {
+	switch {
+	case strings.TrimSpace(c.Name) == "":
+		errs.add(prefix+".Name", "is required")
+	case names[c.Name]:
+		errs.add(prefix+".Name", "%q is used by another container of the task", c.Name)
+	}
+	names[c.Name] = true
+	if strings.TrimSpace(c.Image) == "" {
+		errs.add(prefix+".Image", "is required")
+	} else if err := validImage(c.Image); err != nil {
+		errs.add(prefix+".Image", "invalid image reference %q: %v", c.Image, err)
+	}
+	validateResources(c.CPU, c.Memory, 0, func(name string) string { return prefix + "." + name }, errs)
+}
+
+// ValidateTask checks a task as submitted by a client: its identity and
+// state, its image, resources, ports, restart policy, timeouts and labels,
+// and the parts task.Validate checks.
+func ValidateTask(prefix string, t task.Task, errs *FieldErrors) {
+	if t.ID == uuid.Nil {
+		errs.add(prefix+".ID", "is required")
+	}
+	if t.State != task.Pending {
+		errs.add(prefix+".State", "must be Pending for a new task, got %s", t.State)
+	}
+	if t.ContainerID != "" {
+		errs.add(prefix+".ContainerID", "is set by the worker")
+	}
+	if t.RestartCount != 0 {
+		errs.add(prefix+".RestartCount", "is set by the manager")
+	}
+
+	if t.Image == "" {
+		errs.add(prefix+".Image", "is required")
+	} else if err := validImage(t.Image); err != nil {
+		errs.add(prefix+".Image", "invalid image reference %q: %v", t.Image, err)
+	}
+	validateResources(t.CPU, t.Memory, t.Disk, func(name string) string { return prefix + "." + name }, errs)
+	names := make(map[string]bool)
+	for i, c := range t.InitContainers {
+		validateContainer(fmt.Sprintf("%s.InitContainers[%d]", prefix, i), c, names, errs)
+	}
+	for i, c := range t.Sidecars {
+		validateContainer(fmt.Sprintf("%s.Sidecars[%d]", prefix, i), c, names, errs)
+	}
+
+	for _, p := range slices.Sorted(maps.Keys(t.ExposedPorts)) {
+		if _, err := parsePort(string(p)); err != nil {
+			errs.add(fmt.Sprintf("%s.ExposedPorts[%q]", prefix, p), "%v", err)
+		}
+	}
+	for _, label := range slices.Sorted(maps.Keys(t.PortLabels)) {
+		field := fmt.Sprintf("%s.PortLabels[%q]", prefix, label)
+		if !portLabelRe.MatchString(label) {
+			errs.add(field, "invalid port label")
+		}
+		if _, ok := t.ExposedPorts[t.PortLabels[label]]; !ok {
+			errs.add(field, "port %q is not exposed", t.PortLabels[label])
+		}
+	}
+	if t.RestartPolicy != "" && !slices.Contains(restartPolicies, t.RestartPolicy) {
+		errs.add(prefix+".RestartPolicy", "must be one of no, always, on-failure or unless-stopped, got %q", t.RestartPolicy)
+	}
+
+	if t.MaxRunDuration < 0 {
+		errs.add(prefix+".MaxRunDuration", "must not be negative")
+	}
+	if t.ScheduleDeadline < 0 {
+		errs.add(prefix+".ScheduleDeadline", "must not be negative")
+	}
+	if t.StopSignal != "" && !stopSignalRe.MatchString(t.StopSignal) {
+		errs.add(prefix+".StopSignal", "must be a signal name such as SIGINT or a number, got %q", t.StopSignal)
+	}
+	if t.StopTimeout < 0 {
+		errs.add(prefix+".StopTimeout", "must not be negative")
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(t.Labels)) {
+		field := fmt.Sprintf("%s.Labels[%q]", prefix, k)
+		if !task.ValidLabelKey(k) {
+			errs.add(field, "invalid label key")
+		} else if !task.ValidLabelValue(t.Labels[k]) {
+			errs.add(field, "invalid label value %q", t.Labels[k])
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(t.Annotations)) {
+		if !task.ValidLabelKey(k) {
+			errs.add(fmt.Sprintf("%s.Annotations[%q]", prefix, k), "invalid annotation key")
+		}
+	}
+
+	if err := task.ValidateSecrets(t); err != nil {
+		errs.add(prefix+".Secrets", "%v", err)
+	}
+	if err := task.ValidateTemplates(t); err != nil {
+		errs.add(prefix+".Templates", "%v", err)
+	}
+}
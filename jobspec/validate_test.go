@@ -0,0 +1,67 @@
+package jobspec
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestValidateTaskEvent(t *testing.T) {
+	valid := task.TaskEvent{
+		ID:    uuid.New(),
+		State: task.Scheduled,
+		Task: task.Task{
+			ID:           uuid.New(),
+			Image:        "strm/helloworld-http:latest",
+			Memory:       64 << 20,
+			ExposedPorts: nat.PortSet{"80/tcp": {}},
+		},
+	}
+	if errs := ValidateTaskEvent(valid); len(errs) > 0 {
+		t.Fatalf("valid event: unexpected errors: %v", errs)
+	}
+
+	bad := task.TaskEvent{
+		State: task.Scheduled,
+		Task: task.Task{
+			State:         task.Running,
+			Image:         "Not A Valid/Image",
+			Memory:        -1,
+			ExposedPorts:  nat.PortSet{"99999/tcp": {}},
+			RestartPolicy: "sometimes",
+		},
+	}
+	var fields []string
+	for _, e := range ValidateTaskEvent(bad) {
+		fields = append(fields, e.Field)
+	}
+	want := []string{"ID", "Task.ID", "Task.State", "Task.Image", "Task.Memory", `Task.ExposedPorts["99999/tcp"]`, "Task.RestartPolicy"}
+	if !slices.Equal(fields, want) {
+		t.Errorf("invalid fields = %q, want %q", fields, want)
+	}
+}
+
+func TestValidateTaskContainers(t *testing.T) {
+	tk := task.Task{
+		ID:             uuid.New(),
+		Image:          "nginx",
+		InitContainers: []task.Container{{Name: "migrate", Image: "busybox"}},
+		Sidecars: []task.Container{
+			{Name: "proxy", Image: "envoyproxy/envoy", Memory: 1024},
+			{Name: "migrate"},
+		},
+	}
+	var errs FieldErrors
+	ValidateTask("Task", tk, &errs)
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	want := []string{"Task.Sidecars[0].Memory", "Task.Sidecars[1].Name", "Task.Sidecars[1].Image"}
+	if !slices.Equal(fields, want) {
+		t.Errorf("invalid fields = %q, want %q", fields, want)
+	}
+}
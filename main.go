@@ -9,9 +9,11 @@ import (
 	"syscall"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/ctfrancia/mongeta/cli"
 	"github.com/ctfrancia/mongeta/config"
 	"github.com/ctfrancia/mongeta/logger"
 	"github.com/ctfrancia/mongeta/manager"
+	"github.com/ctfrancia/mongeta/secrets"
 	"github.com/ctfrancia/mongeta/worker"
 )
 
@@ -27,6 +29,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Any arguments make this a client invocation against a running manager.
+	if len(os.Args) > 1 {
+		addr := fmt.Sprintf("%s:%d", cfg.Manager.Host, cfg.Manager.Port)
+		if err := cli.Run(addr, os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
 		syscall.SIGTERM)
 	defer stop()
@@ -34,6 +46,15 @@ func main() {
 	logger.Info("starting Mongeta")
 
 	w := worker.NewWorker(cfg.Worker.QueueSize)
+	w.Name = fmt.Sprintf("%s:%d", cfg.Worker.Host, cfg.Worker.Port)
+	w.TaskDir = cfg.Worker.TaskDir
+	if err := w.CheckTaskDir(); err != nil {
+		logger.Error("unusable worker task directory", "err", err)
+		os.Exit(1)
+	}
+	w.Labels = cfg.Worker.Labels
+	w.MinPort, w.MaxPort = cfg.Worker.MinPort, cfg.Worker.MaxPort
+	w.RetryAfter = cfg.Worker.RunInterval
 	wapi := worker.API{
 		Address:      cfg.Worker.Host,
 		Port:         cfg.Worker.Port,
@@ -45,6 +66,31 @@ func main() {
 
 	workers := []string{fmt.Sprintf("%s:%d", cfg.Worker.Host, cfg.Worker.Port)}
 	m := manager.New(workers, cfg.Manager.QueueSize, cfg.Manager.MaxRestarts)
+	m.IdempotencyTTL = cfg.Manager.IdempotencyTTL
+	m.RetryAfter = cfg.Manager.ProcessInterval
+	if err := m.SetOverflow(cfg.Manager.OverflowSize, cfg.Manager.OverflowFile); err != nil {
+		logger.Error("failed to load overflow queue", "err", err)
+		os.Exit(1)
+	}
+	switch {
+	case cfg.Manager.SecretsKey != "":
+		key, err := secrets.ParseKey(cfg.Manager.SecretsKey)
+		if err != nil {
+			logger.Error("failed to parse secrets key", "err", err)
+			os.Exit(1)
+		}
+		store, err := secrets.New(key, cfg.Manager.SecretsFile)
+		if err != nil {
+			logger.Error("failed to open secrets store", "err", err)
+			os.Exit(1)
+		}
+		m.Secrets = store
+	case cfg.Manager.SecretsFile != "":
+		logger.Error("MONGETA_MANAGER_SECRETS_FILE needs MONGETA_MANAGER_SECRETS_KEY")
+		os.Exit(1)
+	default:
+		logger.Warn("no secrets key configured, secrets are kept in memory only")
+	}
 	mapi := manager.API{
 		Address:      cfg.Manager.Host,
 		Port:         cfg.Manager.Port,
@@ -77,6 +123,15 @@ func main() {
 	wg.Add(1)
 	go func() { defer wg.Done(); m.DoHealthChecks(ctx, cfg.Manager.HealthCheckInterval) }()
 
+	wg.Add(1)
+	go func() { defer wg.Done(); m.ReconcileJobs(ctx, cfg.Manager.ReconcileInterval) }()
+
+	wg.Add(1)
+	go func() { defer wg.Done(); m.ReconcileWorkflows(ctx, cfg.Manager.ReconcileInterval) }()
+
+	wg.Add(1)
+	go func() { defer wg.Done(); m.RunPeriodicJobs(ctx, cfg.Manager.PeriodicInterval) }()
+
 	wg.Add(1)
 	go func() { defer wg.Done(); mapi.Start(ctx) }()
 
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// Allocation is one attempt at running a task: a placement on a worker,
+// with the container it ran in and how that ended. The task itself keeps
+// its ID across restarts and moves; every placement gets a new allocation.
+type Allocation struct {
+	ID     uuid.UUID
+	TaskID uuid.UUID
+	// Attempt is the task's RestartCount when it was placed.
+	Attempt     int
+	Worker      string
+	ContainerID string
+	State       task.State
+	CreateTime  time.Time
+	StartTime   time.Time
+	FinishTime  time.Time
+	Status      task.Status
+	Reason      string
+}
+
+var ErrTaskNotFound = errors.New("task not found")
+
+// newAllocationLocked records the placement of t on worker and makes it
+// the task's current allocation. The caller must hold m.mu.
+func (m *Manager) newAllocationLocked(t *task.Task, worker string) *Allocation {
+	a := &Allocation{
+		ID:         uuid.New(),
+		TaskID:     t.ID,
+		Attempt:    t.RestartCount,
+		Worker:     worker,
+		State:      task.Scheduled,
+		CreateTime: time.Now().UTC(),
+	}
+	m.AllocDB[t.ID] = append(m.AllocDB[t.ID], a)
+	t.AllocID = a.ID
+	return a
+}
+
+// allocationLocked returns the allocation with the given ID of a task. The
+// caller must hold m.mu.
+func (m *Manager) allocationLocked(taskID, allocID uuid.UUID) *Allocation {
+	for _, a := range m.AllocDB[taskID] {
+		if a.ID == allocID {
+			return a
+		}
+	}
+	return nil
+}
+
+// updateAllocationLocked records what a worker reports about one attempt
+// of a task, which need not be the current one. The caller must hold m.mu.
+func (m *Manager) updateAllocationLocked(reported *task.Task) {
+	a := m.allocationLocked(reported.ID, reported.AllocID)
+	if a == nil {
+		return
+	}
+	a.State = reported.State
+	a.ContainerID = reported.ContainerID
+	a.StartTime = reported.StartTime
+	a.FinishTime = reported.FinishTime
+	a.Status = reported.Status
+	a.Reason = reported.Reason
+}
+
+// setAllocationStateLocked sets the state of a task's current allocation
+// for changes the manager makes itself, such as losing its worker. The
+// caller must hold m.mu.
+func (m *Manager) setAllocationStateLocked(t *task.Task, state task.State, reason string) {
+	if a := m.allocationLocked(t.ID, t.AllocID); a != nil {
+		a.State = state
+		a.Reason = reason
+	}
+}
+
+// GetAllocations returns every attempt at running a task, oldest first.
+func (m *Manager) GetAllocations(taskID uuid.UUID) ([]Allocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.TaskDB[taskID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+	}
+	allocs := make([]Allocation, 0, len(m.AllocDB[taskID]))
+	for _, a := range m.AllocDB[taskID] {
+		allocs = append(allocs, *a)
+	}
+	return allocs, nil
+}
@@ -3,9 +3,11 @@ package manager
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"github.com/ctfrancia/mongeta/jobspec"
 	"github.com/ctfrancia/mongeta/logger"
 	"github.com/go-chi/chi/v5"
 )
@@ -23,6 +25,8 @@ type API struct {
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
+	// Errors lists each invalid field of a rejected submission.
+	Errors []jobspec.FieldError `json:",omitempty"`
 }
 
 func (a *API) Start(ctx context.Context) {
@@ -33,6 +37,8 @@ func (a *API) Start(ctx context.Context) {
 		ReadTimeout:  a.ReadTimeout,
 		WriteTimeout: a.WriteTimeout,
 		IdleTimeout:  a.IdleTimeout,
+		// Requests see ctx, so event streams end when the server stops.
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
 
 	go func() {
@@ -50,10 +56,68 @@ func (a *API) Start(ctx context.Context) {
 func (a *API) initRouter() {
 	a.Router = chi.NewRouter()
 	a.Router.Route("/tasks", func(r chi.Router) {
-		r.Post("/", a.StartTaskHandler)
+		r.Post("/", a.idempotent(a.StartTaskHandler))
 		r.Get("/", a.GetTasksHandler)
+		r.Delete("/", a.StopTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
+			r.Get("/", a.GetTaskHandler)
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/allocations", a.GetTaskAllocationsHandler)
+			r.Get("/events", a.GetTaskEventsHandler)
 		})
 	})
+	a.Router.Route("/services", func(r chi.Router) {
+		r.Post("/", a.CreateServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{jobID}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Put("/", a.UpdateServiceHandler)
+			r.Patch("/", a.ScaleServiceHandler)
+			r.Delete("/", a.DeleteServiceHandler)
+			r.Get("/deployments", a.GetServiceDeploymentsHandler)
+		})
+	})
+	a.Router.Route("/jobs", func(r chi.Router) {
+		r.Post("/", a.CreateJobHandler)
+		r.Get("/", a.GetJobsHandler)
+		r.Route("/{jobID}", func(r chi.Router) {
+			r.Get("/", a.GetJobHandler)
+			r.Put("/", a.UpdateJobHandler)
+			r.Delete("/", a.DeleteJobHandler)
+			r.Post("/plan", a.PlanJobHandler)
+			r.Post("/trigger", a.TriggerJobHandler)
+			r.Get("/versions", a.GetJobVersionsHandler)
+			r.Get("/diff", a.GetJobDiffHandler)
+			r.Post("/revert", a.RevertJobHandler)
+		})
+	})
+	a.Router.Route("/workflows", func(r chi.Router) {
+		r.Post("/", a.CreateWorkflowHandler)
+		r.Get("/", a.GetWorkflowsHandler)
+		r.Get("/{workflowID}", a.GetWorkflowHandler)
+	})
+	a.Router.Route("/secrets", func(r chi.Router) {
+		r.Post("/", a.CreateSecretHandler)
+		r.Get("/", a.GetSecretsHandler)
+		r.Delete("/{name}", a.DeleteSecretHandler)
+	})
+	a.Router.Route("/kv", func(r chi.Router) {
+		r.Get("/", a.GetKeysHandler)
+		r.Get("/*", a.GetKeyHandler)
+		r.Put("/*", a.PutKeyHandler)
+		r.Delete("/*", a.DeleteKeyHandler)
+	})
+	a.Router.Route("/events", func(r chi.Router) {
+		r.Get("/", a.GetEventsHandler)
+		r.Get("/stream", a.StreamEventsHandler)
+	})
+	a.Router.Route("/deployments", func(r chi.Router) {
+		r.Get("/", a.GetDeploymentsHandler)
+		r.Route("/{deploymentID}", func(r chi.Router) {
+			r.Get("/", a.GetDeploymentHandler)
+			r.Post("/promote", a.PromoteDeploymentHandler)
+			r.Post("/abort", a.AbortDeploymentHandler)
+		})
+	})
+	a.Router.Get("/metrics", a.MetricsHandler)
 }
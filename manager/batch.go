@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+const (
+	batchBackoffBase = 10 * time.Second
+	batchBackoffMax  = 6 * time.Minute
+)
+
+// batchBackoff is how long to wait after the n-th failed task of a batch job
+// before placing a replacement. It doubles with every failure.
+func batchBackoff(failures int) time.Duration {
+	d := batchBackoffBase
+	for i := 1; i < failures && d < batchBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, batchBackoffMax)
+}
+
+// reconcileBatchJob keeps up to Parallelism tasks of a batch job running
+// until Completions of them have exited 0, or more than BackoffLimit have
+// failed. Tasks that were stopped count as neither.
+func (m *Manager) reconcileBatchJob(j *Job) {
+	// Periodic jobs only launch runs; the runs do the work.
+	if j.Periodic != nil || j.Status.State != JobRunning {
+		return
+	}
+
+	var active []*task.Task
+	var completed, failed int
+	var lastFailure time.Time
+	for _, t := range m.jobTasks(j.ID) {
+		switch t.State {
+		case task.Pending, task.Scheduled, task.Running, task.Restarting, task.Lost, task.Unknown:
+			active = append(active, t)
+		case task.Completed:
+			// Stopped tasks are Completed too, but only a container
+			// that exited 0 counts.
+			if t.Status.Succeeded() {
+				completed++
+			}
+		case task.Failed:
+			failed++
+			if t.FinishTime.After(lastFailure) {
+				lastFailure = t.FinishTime
+			}
+		}
+	}
+
+	switch {
+	case completed >= j.Completions:
+		for _, t := range active {
+			m.stopTask(*t, SourceManager, "job succeeded")
+		}
+		m.finishJob(j.ID, JobSucceeded, fmt.Sprintf("%d of %d completions", completed, j.Completions))
+		return
+	case failed > j.BackoffLimit:
+		for _, t := range active {
+			m.stopTask(*t, SourceManager, "backoff limit exceeded")
+		}
+		m.finishJob(j.ID, JobFailed, fmt.Sprintf("backoff limit exceeded: %d task(s) failed", failed))
+		return
+	}
+
+	if failed > 0 && time.Since(lastFailure) < batchBackoff(failed) {
+		logger.Debug("batch job backing off", "job_id", j.ID, "failed", failed)
+		return
+	}
+
+	place := min(j.Parallelism-len(active), j.Completions-completed-len(active))
+	for range max(place, 0) {
+		m.submitTask(j.newTask())
+	}
+}
+
+func (m *Manager) finishJob(id uuid.UUID, state JobState, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.JobDB[id]
+	if !ok {
+		return
+	}
+	j.Status.State = state
+	j.Status.Reason = reason
+	j.Status.CompletionTime = time.Now().UTC()
+	logger.Info("job finished", "job_id", id, "state", state, "reason", reason)
+}
@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+func newTestBatchJob(t *testing.T, m *Manager, completions, parallelism, backoffLimit int) *Job {
+	t.Helper()
+	j, err := m.AddJob(Job{
+		Name:         "report",
+		Type:         JobTypeBatch,
+		Template:     task.Task{Image: "busybox"},
+		Completions:  completions,
+		Parallelism:  parallelism,
+		BackoffLimit: backoffLimit,
+	})
+	if err != nil {
+		t.Fatalf("AddJob: unexpected error: %v", err)
+	}
+	return j
+}
+
+// finishActive simulates the worker reporting every active task of a job as
+// exited with the given state.
+func finishActive(m *Manager, jobID uuid.UUID, state task.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.TaskDB {
+		if t.JobID == jobID && (t.State == task.Pending || t.State == task.Scheduled || t.State == task.Running) {
+			t.State = state
+			t.FinishTime = time.Now().Add(-time.Hour)
+			t.Status = task.Status{ExitCode: 1, Reason: "exited with code 1", FinishedAt: t.FinishTime}
+			if state == task.Completed {
+				t.Status = task.Status{Reason: "exited successfully", FinishedAt: t.FinishTime}
+			}
+		}
+	}
+}
+
+func TestBatchJobCompletes(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestBatchJob(t, m, 3, 2, 0)
+
+	m.reconcileJobs()
+	if got := len(m.jobTasks(j.ID)); got != 2 {
+		t.Fatalf("tasks after first pass = %d, want 2", got)
+	}
+
+	finishActive(m, j.ID, task.Completed)
+	m.reconcileJobs()
+	if got := len(m.jobTasks(j.ID)); got != 3 {
+		t.Fatalf("tasks after second pass = %d, want 3", got)
+	}
+
+	finishActive(m, j.ID, task.Completed)
+	m.reconcileJobs()
+	got, _ := m.GetJob(j.ID)
+	if got.Status.State != JobSucceeded {
+		t.Errorf("State = %s, want succeeded", got.Status.State)
+	}
+	if got.Status.Completed != 3 {
+		t.Errorf("Completed = %d, want 3", got.Status.Completed)
+	}
+
+	m.reconcileJobs()
+	if n := len(m.jobTasks(j.ID)); n != 3 {
+		t.Errorf("finished job placed more tasks: %d, want 3", n)
+	}
+}
+
+func TestBatchJobIgnoresStoppedTasks(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestBatchJob(t, m, 1, 1, 0)
+	m.reconcileJobs()
+
+	// Stopping an undispatched task marks it Completed without a status.
+	for _, tk := range m.jobTasks(j.ID) {
+		m.stopTask(*tk, SourceUser, "stopped by user")
+	}
+	m.reconcileJobs()
+	got, _ := m.GetJob(j.ID)
+	if got.Status.State != JobRunning {
+		t.Errorf("State = %s, want running", got.Status.State)
+	}
+	if n := len(m.jobTasks(j.ID)); n != 2 {
+		t.Errorf("tasks = %d, want a replacement for the stopped one", n)
+	}
+}
+
+func TestBatchJobBackoffLimit(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestBatchJob(t, m, 1, 1, 1)
+
+	m.reconcileJobs()
+	finishActive(m, j.ID, task.Failed)
+	m.reconcileJobs()
+	if got := len(m.jobTasks(j.ID)); got != 2 {
+		t.Fatalf("tasks after one failure = %d, want a retry", got)
+	}
+
+	finishActive(m, j.ID, task.Failed)
+	m.reconcileJobs()
+	got, _ := m.GetJob(j.ID)
+	if got.Status.State != JobFailed {
+		t.Errorf("State = %s, want failed", got.Status.State)
+	}
+}
+
+func TestBatchTasksAreNotRestarted(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestBatchJob(t, m, 1, 1, 0)
+	m.reconcileJobs()
+	finishActive(m, j.ID, task.Failed)
+	<-m.Pending
+
+	m.doHealthChecks()
+	if got := len(m.Pending); got != 0 {
+		t.Errorf("queued events = %d, want 0", got)
+	}
+}
+
+func TestBatchBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{10, 6 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := batchBackoff(tt.failures); got != tt.want {
+			t.Errorf("batchBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
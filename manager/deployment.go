@@ -0,0 +1,529 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// UpdateType selects how a deployment introduces the new version.
+type UpdateType string
+
+const (
+	// UpdateRolling replaces old tasks with new ones a few at a time.
+	UpdateRolling UpdateType = "rolling"
+	// UpdateCanary starts Canary new tasks next to the old set and waits
+	// for promotion before continuing as a rolling update.
+	UpdateCanary UpdateType = "canary"
+	// UpdateBlueGreen starts a complete new set next to the old set and
+	// stops the old set only on promotion.
+	UpdateBlueGreen UpdateType = "blue-green"
+)
+
+// UpdateStrategy controls how a service's tasks are replaced when its
+// template changes.
+//
+// MaxParallel caps how many new tasks may be placed but not yet healthy at
+// any one time. MaxSurge is how many tasks may run above Replicas during the
+// update; when it is smaller than MaxParallel the difference is taken out of
+// the old tasks instead, so availability may dip by MaxParallel-MaxSurge.
+// A new task is healthy once it has been Running and passing its health
+// check for MinHealthyTime, and unhealthy if it fails, is restarted, fails a
+// health check, or is not healthy within HealthyDeadline of starting.
+type UpdateStrategy struct {
+	Type            UpdateType
+	Canary          int
+	MaxParallel     int
+	MaxSurge        int
+	MinHealthyTime  time.Duration
+	HealthyDeadline time.Duration
+	AutoRevert      bool
+}
+
+const (
+	defaultMaxParallel     = 1
+	defaultMinHealthyTime  = 10 * time.Second
+	defaultHealthyDeadline = 5 * time.Minute
+)
+
+func (s UpdateStrategy) withDefaults() UpdateStrategy {
+	if s.Type == "" {
+		s.Type = UpdateRolling
+	}
+	if s.Type == UpdateCanary && s.Canary <= 0 {
+		s.Canary = 1
+	}
+	if s.MaxParallel <= 0 {
+		s.MaxParallel = defaultMaxParallel
+	}
+	if s.MaxSurge < 0 {
+		s.MaxSurge = 0
+	}
+	if s.MinHealthyTime <= 0 {
+		s.MinHealthyTime = defaultMinHealthyTime
+	}
+	if s.HealthyDeadline <= 0 {
+		s.HealthyDeadline = defaultHealthyDeadline
+	}
+	return s
+}
+
+func validateStrategy(s UpdateStrategy) error {
+	switch s.Type {
+	case "", UpdateRolling, UpdateCanary, UpdateBlueGreen:
+	default:
+		return fmt.Errorf("%w: unknown update type %q", ErrInvalidJobSpec, s.Type)
+	}
+	if s.Canary < 0 {
+		return fmt.Errorf("%w: canary count must not be negative", ErrInvalidJobSpec)
+	}
+	return nil
+}
+
+type DeploymentStatus string
+
+const (
+	DeploymentRunning    DeploymentStatus = "running"
+	DeploymentSuccessful DeploymentStatus = "successful"
+	DeploymentFailed     DeploymentStatus = "failed"
+	DeploymentCancelled  DeploymentStatus = "cancelled"
+)
+
+// Deployment tracks the rollout of one version of a job's template.
+type Deployment struct {
+	ID                uuid.UUID
+	JobID             uuid.UUID
+	JobVersion        int
+	PreviousVersion   int
+	Strategy          UpdateStrategy
+	Status            DeploymentStatus
+	StatusDescription string
+	RequiresPromotion bool
+	Promoted          bool
+	DesiredTotal      int
+	PlacedTasks       int
+	HealthyTasks      int
+	UnhealthyTasks    int
+	CreateTime        time.Time
+	UpdateTime        time.Time
+
+	// rollback is the template that was replaced, restored on revert or
+	// abort.
+	rollback task.Task
+}
+
+var (
+	ErrDeploymentNotFound  = errors.New("deployment not found")
+	ErrDeploymentNotActive = errors.New("deployment is not running")
+	ErrDeploymentNotReady  = errors.New("deployment is not ready for promotion")
+)
+
+// UpdateJob replaces the spec of a job, recording the result as a new
+// version. For a service, a template change starts a deployment, cancelling
+// any deployment that is still running; other changes apply to the running
+// tasks in place. For a batch or periodic job, the new spec applies to the
+// tasks and runs launched from then on. A spec identical to the current one
+// changes nothing.
+func (m *Manager) UpdateJob(id uuid.UUID, spec Job) (*Job, error) {
+	return m.updateJob(id, spec, nil)
+}
+
+// ApplyJob is UpdateJob guarded by the check index of an earlier plan: it
+// fails with ErrCheckIndexMismatch if the job has changed since.
+func (m *Manager) ApplyJob(id uuid.UUID, spec Job, checkIndex uint64) (*Job, error) {
+	return m.updateJob(id, spec, &checkIndex)
+}
+
+func (m *Manager) updateJob(id uuid.UUID, spec Job, checkIndex *uint64) (*Job, error) {
+	m.mu.Lock()
+	j, ok := m.JobDB[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrJobNotFound
+	}
+	if checkIndex != nil && *checkIndex != j.ModifyIndex {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: plan was made at index %d, job is at %d", ErrCheckIndexMismatch, *checkIndex, j.ModifyIndex)
+	}
+
+	if err := validateJobUpdate(j, &spec); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	if len(DiffJobs(*j, spec)) > 0 {
+		m.applySpecLocked(j, spec)
+	}
+	c := *j
+	m.mu.Unlock()
+
+	logger.Info("updated job", "job_id", id, "version", c.Version)
+	return &c, nil
+}
+
+// validateJobUpdate checks spec as the next version of j and fills in its
+// defaults. Its name and type are taken from j, and a batch job cannot be
+// made periodic, or stop being periodic, by an update.
+func validateJobUpdate(j *Job, spec *Job) error {
+	spec.Name = j.Name
+	spec.Type = j.Type
+	if err := validateJob(spec); err != nil {
+		return err
+	}
+	if (spec.Periodic == nil) != (j.Periodic == nil) {
+		return fmt.Errorf("%w: a job cannot be made periodic or stop being periodic; create a new job instead", ErrInvalidJobSpec)
+	}
+	setJobDefaults(spec)
+	return nil
+}
+
+// startDeploymentLocked swaps in a new template and records a deployment for
+// it. The caller must hold m.mu.
+func (m *Manager) startDeploymentLocked(j *Job, template task.Task, strategy UpdateStrategy) *Deployment {
+	for _, d := range m.DeploymentDB {
+		if d.JobID == j.ID && d.Status == DeploymentRunning {
+			d.Status = DeploymentCancelled
+			d.StatusDescription = "superseded by a newer version"
+			d.UpdateTime = time.Now().UTC()
+		}
+	}
+
+	version := m.nextVersionLocked(j)
+
+	now := time.Now().UTC()
+	d := &Deployment{
+		ID:                uuid.New(),
+		JobID:             j.ID,
+		JobVersion:        version,
+		PreviousVersion:   j.Version,
+		Strategy:          strategy,
+		Status:            DeploymentRunning,
+		RequiresPromotion: strategy.Type == UpdateCanary || strategy.Type == UpdateBlueGreen,
+		DesiredTotal:      j.Replicas,
+		CreateTime:        now,
+		UpdateTime:        now,
+		rollback:          j.Template,
+	}
+	m.DeploymentDB[d.ID] = d
+
+	j.Template = template
+	j.Version = version
+	m.touchLocked(j)
+
+	logger.Info("started deployment", "deployment_id", d.ID, "job_id", j.ID, "version", j.Version)
+	return d
+}
+
+// GetDeployment returns a copy of the deployment with the given ID.
+func (m *Manager) GetDeployment(id uuid.UUID) (*Deployment, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d, ok := m.DeploymentDB[id]
+	if !ok {
+		return nil, false
+	}
+	c := *d
+	return &c, true
+}
+
+// GetDeployments returns copies of all deployments, newest first, optionally
+// restricted to one job.
+func (m *Manager) GetDeployments(jobID uuid.UUID) []*Deployment {
+	m.mu.RLock()
+	deployments := make([]*Deployment, 0, len(m.DeploymentDB))
+	for _, d := range m.DeploymentDB {
+		if jobID != uuid.Nil && d.JobID != jobID {
+			continue
+		}
+		c := *d
+		deployments = append(deployments, &c)
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(deployments, func(a, b *Deployment) int {
+		return b.CreateTime.Compare(a.CreateTime)
+	})
+	return deployments
+}
+
+func (m *Manager) activeDeployment(jobID uuid.UUID) (*Deployment, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, d := range m.DeploymentDB {
+		if d.JobID == jobID && d.Status == DeploymentRunning {
+			c := *d
+			return &c, true
+		}
+	}
+	return nil, false
+}
+
+type taskHealth int
+
+const (
+	healthPending taskHealth = iota
+	healthOK
+	healthBad
+)
+
+// deploymentHealth classifies a task of the version being deployed.
+func (m *Manager) deploymentHealth(t *task.Task, s UpdateStrategy, now time.Time) taskHealth {
+	if t.State == task.Failed || t.RestartCount > 0 {
+		return healthBad
+	}
+	if t.State != task.Running || t.StartTime.IsZero() {
+		return healthPending
+	}
+
+	healthy, checked := m.lastHealth(t.ID)
+	if checked && !healthy {
+		return healthBad
+	}
+	running := now.Sub(t.StartTime)
+	if checked && running >= s.MinHealthyTime {
+		return healthOK
+	}
+	if running > s.HealthyDeadline {
+		return healthBad
+	}
+	return healthPending
+}
+
+// progressDeployment moves a running deployment one step forward: it stops
+// old tasks that have been replaced, places new tasks within the strategy's
+// limits, and marks the deployment successful or failed.
+func (m *Manager) progressDeployment(j *Job, d *Deployment) {
+	var old, fresh []*task.Task
+	for _, t := range m.liveTasks(j.ID) {
+		if t.JobVersion == j.Version {
+			fresh = append(fresh, t)
+		} else {
+			old = append(old, t)
+		}
+	}
+
+	now := time.Now()
+	var healthy, unhealthy int
+	for _, t := range fresh {
+		switch m.deploymentHealth(t, d.Strategy, now) {
+		case healthOK:
+			healthy++
+		case healthBad:
+			unhealthy++
+		}
+	}
+	m.updateDeploymentProgress(d.ID, j.Replicas, len(fresh), healthy, unhealthy)
+
+	if unhealthy > 0 {
+		m.failDeployment(d.ID, fmt.Sprintf("%d task(s) of version %d unhealthy", unhealthy, j.Version))
+		return
+	}
+	if len(old) == 0 && healthy >= j.Replicas {
+		for _, t := range fresh[j.Replicas:] {
+			m.stopTask(*t, SourceManager, "surplus replica")
+		}
+		m.finishDeployment(d.ID, DeploymentSuccessful, "all tasks healthy")
+		return
+	}
+
+	s := d.Strategy
+	desired := j.Replicas
+
+	if s.Type == UpdateBlueGreen {
+		if d.Promoted {
+			for _, t := range old {
+				m.stopTask(*t, SourceManager, "replaced by new version")
+			}
+			return
+		}
+		m.placeForPromotion(j, d, desired, len(fresh), healthy)
+		return
+	}
+	if s.Type == UpdateCanary && !d.Promoted {
+		m.placeForPromotion(j, d, min(s.Canary, desired), len(fresh), healthy)
+		return
+	}
+	if len(fresh) > desired {
+		for _, t := range fresh[desired:] {
+			m.stopTask(*t, SourceManager, "surplus replica")
+		}
+		fresh = fresh[:desired]
+	}
+
+	minAvailable := max(desired-max(s.MaxParallel-s.MaxSurge, 0), 0)
+	stop := min(len(old)+healthy-minAvailable, len(old))
+	for _, t := range old[:max(stop, 0)] {
+		m.stopTask(*t, SourceManager, "replaced by new version")
+	}
+	total := len(old) - max(stop, 0) + len(fresh)
+
+	inFlight := len(fresh) - healthy
+	place := min(s.MaxParallel-inFlight, desired-len(fresh), desired+s.MaxSurge-total)
+	for range max(place, 0) {
+		m.submitTask(j.newTask())
+	}
+}
+
+// placeForPromotion tops the new version up to want tasks next to the old
+// ones and notes when the deployment is ready to be promoted.
+func (m *Manager) placeForPromotion(j *Job, d *Deployment, want, placed, healthy int) {
+	for range max(want-placed, 0) {
+		m.submitTask(j.newTask())
+	}
+	if healthy >= want {
+		m.setDeploymentDescription(d.ID, fmt.Sprintf("%d new task(s) healthy, awaiting promotion", healthy))
+	}
+}
+
+func (m *Manager) setDeploymentDescription(id uuid.UUID, desc string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d, ok := m.DeploymentDB[id]; ok {
+		d.StatusDescription = desc
+	}
+}
+
+// PromoteDeployment lets a canary deployment continue as a rolling update,
+// or a blue/green deployment stop its old set. All new tasks placed so far
+// must be healthy.
+func (m *Manager) PromoteDeployment(id uuid.UUID) (*Deployment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.DeploymentDB[id]
+	if !ok {
+		return nil, ErrDeploymentNotFound
+	}
+	if d.Status != DeploymentRunning {
+		return nil, ErrDeploymentNotActive
+	}
+	if !d.RequiresPromotion || d.Promoted {
+		return nil, fmt.Errorf("%w: deployment does not need promotion", ErrDeploymentNotReady)
+	}
+	if d.PlacedTasks == 0 || d.HealthyTasks < d.PlacedTasks {
+		return nil, fmt.Errorf("%w: %d of %d new task(s) healthy", ErrDeploymentNotReady, d.HealthyTasks, d.PlacedTasks)
+	}
+
+	d.Promoted = true
+	d.StatusDescription = "promoted"
+	d.UpdateTime = time.Now().UTC()
+	logger.Info("promoted deployment", "deployment_id", id, "job_id", d.JobID)
+	c := *d
+	return &c, nil
+}
+
+// AbortDeployment stops the tasks of the version being deployed and puts the
+// job's previous template and version back. Tasks of the previous version
+// that are still running keep running and the reconciler replaces any that
+// were already stopped.
+func (m *Manager) AbortDeployment(id uuid.UUID) (*Deployment, error) {
+	m.mu.Lock()
+	d, ok := m.DeploymentDB[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrDeploymentNotFound
+	}
+	if d.Status != DeploymentRunning {
+		m.mu.Unlock()
+		return nil, ErrDeploymentNotActive
+	}
+	m.abortLocked(d, "aborted")
+	c := *d
+	m.mu.Unlock()
+
+	for _, t := range m.liveTasks(d.JobID) {
+		if t.JobVersion == c.JobVersion {
+			m.stopTask(*t, SourceManager, "deployment aborted")
+		}
+	}
+	return &c, nil
+}
+
+// abortLocked marks d failed and restores the job to d's previous version.
+// The caller must hold m.mu and stop the new version's tasks.
+func (m *Manager) abortLocked(d *Deployment, desc string) {
+	d.Status = DeploymentFailed
+	d.StatusDescription = desc
+	d.UpdateTime = time.Now().UTC()
+
+	j, ok := m.JobDB[d.JobID]
+	if !ok || j.Version != d.JobVersion {
+		return
+	}
+	j.Template = d.rollback
+	j.Version = d.PreviousVersion
+	j.UpdateTime = time.Now().UTC()
+	m.touchLocked(j)
+	logger.Warn("deployment aborted, job restored", "deployment_id", d.ID, "job_id", j.ID, "version", j.Version)
+}
+
+func (m *Manager) updateDeploymentProgress(id uuid.UUID, desired, placed, healthy, unhealthy int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.DeploymentDB[id]
+	if !ok {
+		return
+	}
+	d.DesiredTotal = desired
+	d.PlacedTasks = placed
+	d.HealthyTasks = healthy
+	d.UnhealthyTasks = unhealthy
+	d.UpdateTime = time.Now().UTC()
+}
+
+func (m *Manager) finishDeployment(id uuid.UUID, status DeploymentStatus, desc string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.DeploymentDB[id]
+	if !ok {
+		return
+	}
+	d.Status = status
+	d.StatusDescription = desc
+	d.UpdateTime = time.Now().UTC()
+	logger.Info("deployment finished", "deployment_id", id, "status", status, "reason", desc)
+}
+
+// failDeployment marks a deployment failed and, if its strategy asks for it,
+// rolls the job back to the template it replaced. Canary and blue/green
+// deployments still have the old set running, so they are simply aborted.
+// A rolling update has already replaced some tasks, so its rollback is
+// itself a deployment, with auto-revert disabled so a bad previous version
+// cannot cause the two to flip back and forth.
+func (m *Manager) failDeployment(id uuid.UUID, desc string) {
+	m.finishDeployment(id, DeploymentFailed, desc)
+
+	m.mu.Lock()
+	d := m.DeploymentDB[id]
+	if !d.Strategy.AutoRevert {
+		m.mu.Unlock()
+		return
+	}
+	if d.RequiresPromotion && !d.Promoted {
+		m.abortLocked(d, desc)
+		version := d.JobVersion
+		m.mu.Unlock()
+		for _, t := range m.liveTasks(d.JobID) {
+			if t.JobVersion == version {
+				m.stopTask(*t, SourceManager, "deployment failed")
+			}
+		}
+		return
+	}
+	defer m.mu.Unlock()
+	j, ok := m.JobDB[d.JobID]
+	if !ok || j.Version != d.JobVersion {
+		return
+	}
+
+	strategy := d.Strategy
+	strategy.AutoRevert = false
+	rd := m.startDeploymentLocked(j, d.rollback, strategy)
+	rd.StatusDescription = fmt.Sprintf("reverting to version %d", d.PreviousVersion)
+	m.recordVersionLocked(j)
+	logger.Warn("auto-reverting job", "job_id", j.ID, "failed_version", d.JobVersion, "reverted_from", d.PreviousVersion)
+}
@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// markHealthy simulates the worker reporting every live task of a job as
+// running and the health checker passing it.
+func markHealthy(m *Manager, jobID uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.TaskDB {
+		if t.JobID == jobID && (t.State == task.Pending || t.State == task.Scheduled) {
+			t.State = task.Running
+			t.StartTime = time.Now().Add(-time.Hour)
+			m.health[t.ID] = true
+		}
+	}
+}
+
+func countVersions(m *Manager, jobID uuid.UUID) map[int]int {
+	counts := make(map[int]int)
+	for _, t := range m.liveTasks(jobID) {
+		counts[t.JobVersion]++
+	}
+	return counts
+}
+
+func TestRollingUpdate(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 2)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	spec := *j
+	spec.Template.Image = "strm/helloworld-http:v2"
+	updated, err := m.UpdateJob(j.ID, spec)
+	if err != nil {
+		t.Fatalf("UpdateJob: unexpected error: %v", err)
+	}
+	if updated.Version != 1 {
+		t.Fatalf("Version = %d, want 1", updated.Version)
+	}
+
+	// MaxParallel 1, MaxSurge 0: one old task is replaced at a time.
+	m.reconcileJobs()
+	if got := countVersions(m, j.ID); got[0] != 1 || got[1] != 1 {
+		t.Fatalf("after first step versions = %v, want one of each", got)
+	}
+
+	// Nothing moves until the new task is healthy.
+	m.reconcileJobs()
+	if got := countVersions(m, j.ID); got[0] != 1 || got[1] != 1 {
+		t.Fatalf("while waiting for health versions = %v, want one of each", got)
+	}
+
+	markHealthy(m, j.ID)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+	m.reconcileJobs()
+
+	if got := countVersions(m, j.ID); got[0] != 0 || got[1] != 2 {
+		t.Errorf("final versions = %v, want two of version 1", got)
+	}
+	ds := m.GetDeployments(j.ID)
+	if len(ds) != 1 || ds[0].Status != DeploymentSuccessful {
+		t.Errorf("deployments = %+v, want one successful", ds)
+	}
+}
+
+func TestRollingUpdateAutoRevert(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 2)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	spec := *j
+	spec.Template.Image = "strm/helloworld-http:broken"
+	spec.Update.AutoRevert = true
+	if _, err := m.UpdateJob(j.ID, spec); err != nil {
+		t.Fatalf("UpdateJob: unexpected error: %v", err)
+	}
+	m.reconcileJobs()
+
+	m.mu.Lock()
+	for _, tk := range m.TaskDB {
+		if tk.JobVersion == 1 {
+			tk.State = task.Failed
+			m.TaskWorkerMap[tk.ID] = "w1:8080"
+		}
+	}
+	m.mu.Unlock()
+	m.reconcileJobs()
+
+	got, _ := m.GetJob(j.ID)
+	if got.Template.Image != "strm/helloworld-http" {
+		t.Errorf("Template.Image = %q, want the original image", got.Template.Image)
+	}
+	if got.Version != 2 {
+		t.Errorf("Version = %d, want 2", got.Version)
+	}
+
+	ds := m.GetDeployments(j.ID)
+	var failed, running int
+	for _, d := range ds {
+		switch d.Status {
+		case DeploymentFailed:
+			failed++
+		case DeploymentRunning:
+			running++
+			if d.Strategy.AutoRevert {
+				t.Error("revert deployment must not auto-revert")
+			}
+		}
+	}
+	if failed != 1 || running != 1 {
+		t.Errorf("deployments failed=%d running=%d, want 1 and 1", failed, running)
+	}
+}
+
+func startUpdate(t *testing.T, m *Manager, j *Job, s UpdateStrategy) *Deployment {
+	t.Helper()
+	spec := *j
+	spec.Template.Image = "strm/helloworld-http:v2"
+	spec.Update = s
+	if _, err := m.UpdateJob(j.ID, spec); err != nil {
+		t.Fatalf("UpdateJob: unexpected error: %v", err)
+	}
+	d, ok := m.activeDeployment(j.ID)
+	if !ok {
+		t.Fatal("expected a running deployment")
+	}
+	return d
+}
+
+func TestCanaryDeployment(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 3)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	d := startUpdate(t, m, j, UpdateStrategy{Type: UpdateCanary, Canary: 1})
+	m.reconcileJobs()
+	if got := countVersions(m, j.ID); got[0] != 3 || got[1] != 1 {
+		t.Fatalf("versions with canary = %v, want 3 old and 1 new", got)
+	}
+	if _, err := m.PromoteDeployment(d.ID); !errors.Is(err, ErrDeploymentNotReady) {
+		t.Errorf("promote before healthy error = %v, want ErrDeploymentNotReady", err)
+	}
+
+	// The canary stays alone until promoted.
+	markHealthy(m, j.ID)
+	m.reconcileJobs()
+	m.reconcileJobs()
+	if got := countVersions(m, j.ID); got[0] != 3 || got[1] != 1 {
+		t.Fatalf("versions before promotion = %v, want 3 old and 1 new", got)
+	}
+
+	if _, err := m.PromoteDeployment(d.ID); err != nil {
+		t.Fatalf("PromoteDeployment: unexpected error: %v", err)
+	}
+	for range 5 {
+		m.reconcileJobs()
+		markHealthy(m, j.ID)
+	}
+	m.reconcileJobs()
+
+	if got := countVersions(m, j.ID); got[0] != 0 || got[1] != 3 {
+		t.Errorf("final versions = %v, want 3 new", got)
+	}
+	if got, _ := m.GetDeployment(d.ID); got.Status != DeploymentSuccessful {
+		t.Errorf("Status = %s, want successful", got.Status)
+	}
+}
+
+func TestBlueGreenDeployment(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 2)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	d := startUpdate(t, m, j, UpdateStrategy{Type: UpdateBlueGreen})
+	m.reconcileJobs()
+	if got := countVersions(m, j.ID); got[0] != 2 || got[1] != 2 {
+		t.Fatalf("versions before promotion = %v, want 2 of each", got)
+	}
+
+	markHealthy(m, j.ID)
+	m.reconcileJobs()
+	if _, err := m.PromoteDeployment(d.ID); err != nil {
+		t.Fatalf("PromoteDeployment: unexpected error: %v", err)
+	}
+	m.reconcileJobs()
+	m.reconcileJobs()
+
+	if got := countVersions(m, j.ID); got[0] != 0 || got[1] != 2 {
+		t.Errorf("final versions = %v, want 2 new", got)
+	}
+	if got, _ := m.GetDeployment(d.ID); got.Status != DeploymentSuccessful {
+		t.Errorf("Status = %s, want successful", got.Status)
+	}
+}
+
+func TestAbortDeployment(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 2)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	d := startUpdate(t, m, j, UpdateStrategy{Type: UpdateCanary, Canary: 1})
+	m.reconcileJobs()
+
+	if _, err := m.AbortDeployment(d.ID); err != nil {
+		t.Fatalf("AbortDeployment: unexpected error: %v", err)
+	}
+	if got := countVersions(m, j.ID); got[0] != 2 || got[1] != 0 {
+		t.Errorf("versions after abort = %v, want 2 old", got)
+	}
+	got, _ := m.GetJob(j.ID)
+	if got.Version != 0 || got.Template.Image != "strm/helloworld-http" {
+		t.Errorf("job after abort = version %d image %q, want version 0 and the original image", got.Version, got.Template.Image)
+	}
+	if _, err := m.AbortDeployment(d.ID); !errors.Is(err, ErrDeploymentNotActive) {
+		t.Errorf("second abort error = %v, want ErrDeploymentNotActive", err)
+	}
+
+	// The next update must not reuse the aborted version number.
+	next := startUpdate(t, m, got, UpdateStrategy{})
+	if next.JobVersion != 2 {
+		t.Errorf("next JobVersion = %d, want 2", next.JobVersion)
+	}
+}
@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// heartbeatInterval is how often an idle event stream sends a comment, so
+// proxies and clients can tell it is still alive.
+const heartbeatInterval = 15 * time.Second
+
+// eventPageParams reads the since and limit query parameters of an event
+// listing.
+func eventPageParams(w http.ResponseWriter, r *http.Request) (since uint64, limit int, ok bool) {
+	q := r.URL.Query()
+	if v := q.Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid since: %q", v))
+			return 0, 0, false
+		}
+		since = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: %q", v))
+			return 0, 0, false
+		}
+		limit = n
+	}
+	return since, limit, true
+}
+
+// GetEventsHandler pages through the events of all tasks.
+func (a *API) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := eventPageParams(w, r)
+	if !ok {
+		return
+	}
+	page, err := a.Manager.GetEvents(uuid.Nil, since, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// GetTaskEventsHandler pages through the events of one task.
+func (a *API) GetTaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task ID: %v", err))
+		return
+	}
+	since, limit, ok := eventPageParams(w, r)
+	if !ok {
+		return
+	}
+	page, err := a.Manager.GetEvents(id, since, limit)
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// eventFilterParams reads the task, job, node and type query parameters of
+// an event stream. Types may be repeated or given as a comma-separated list.
+func eventFilterParams(w http.ResponseWriter, r *http.Request) (EventFilter, bool) {
+	q := r.URL.Query()
+	var f EventFilter
+	for name, id := range map[string]*uuid.UUID{"task": &f.TaskID, "job": &f.JobID} {
+		if v := q.Get(name); v != "" {
+			parsed, err := uuid.Parse(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s ID: %v", name, err))
+				return EventFilter{}, false
+			}
+			*id = parsed
+		}
+	}
+	f.Worker = q.Get("node")
+	for _, v := range q["type"] {
+		for _, typ := range strings.Split(v, ",") {
+			if !slices.Contains(eventTypes, EventType(typ)) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown event type %q", typ))
+				return EventFilter{}, false
+			}
+			f.Types = append(f.Types, EventType(typ))
+		}
+	}
+	return f, true
+}
+
+// StreamEventsHandler streams events as Server-Sent Events, each with its
+// Index as the event ID. A client resumes after a disconnect by sending the
+// last ID it saw as Last-Event-ID, or as the since query parameter.
+func (a *API) StreamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	f, ok := eventFilterParams(w, r)
+	if !ok {
+		return
+	}
+	since, _, ok := eventPageParams(w, r)
+	if !ok {
+		return
+	}
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid Last-Event-ID: %q", v))
+			return
+		}
+		since = n
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	backlog, sub := a.Manager.WatchEvents(f, since)
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range backlog {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logger.Error("cannot flush event stream", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			// A closed channel means the client fell behind; it
+			// reconnects and resumes from the last event it got.
+			if !ok || writeEvent(w, e) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Index, e.Type, data)
+	return err
+}
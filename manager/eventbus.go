@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind by
+// before it is dropped.
+const subscriberBuffer = 64
+
+// EventFilter selects events by task, job, worker and type. Zero fields
+// match everything.
+type EventFilter struct {
+	TaskID uuid.UUID
+	JobID  uuid.UUID
+	Worker string
+	Types  []EventType
+}
+
+func (f EventFilter) Matches(e Event) bool {
+	switch {
+	case f.TaskID != uuid.Nil && e.TaskID != f.TaskID:
+		return false
+	case f.JobID != uuid.Nil && e.JobID != f.JobID:
+		return false
+	case f.Worker != "" && e.Worker != f.Worker:
+		return false
+	case len(f.Types) > 0 && !slices.Contains(f.Types, e.Type):
+		return false
+	}
+	return true
+}
+
+// Subscription receives the events matching its filter as they are
+// recorded. C is closed when the subscriber falls too far behind or
+// unsubscribes; it can resume from the last Index it saw.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter EventFilter
+	bus    *eventBus
+}
+
+// eventBus fans recorded events out to subscribers.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) subscribe(f EventFilter) *Subscription {
+	c := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: c, c: c, filter: f, bus: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// publish hands e to every matching subscriber without blocking, dropping
+// those whose buffer is full.
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.filter.Matches(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			delete(b.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// Unsubscribe stops delivery to s. It is safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
+
+// WatchEvents subscribes to the events matching f. The events already
+// recorded after since are returned first, and the subscription carries on
+// from there without gaps. Events older than the manager keeps are lost.
+func (m *Manager) WatchEvents(f EventFilter, since uint64) ([]Event, *Subscription) {
+	// Events are recorded under the write lock, so none can slip in
+	// between reading the backlog and subscribing.
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var backlog []Event
+	for _, e := range m.eventsSinceLocked(since) {
+		if f.Matches(e) {
+			backlog = append(backlog, e)
+		}
+	}
+	return backlog, m.bus.subscribe(f)
+}
+
+// eventsSinceLocked returns the kept events recorded after since. The
+// caller must hold m.mu.
+func (m *Manager) eventsSinceLocked(since uint64) []Event {
+	i, _ := slices.BinarySearchFunc(m.events, since+1, func(e Event, index uint64) int {
+		return cmp.Compare(e.Index, index)
+	})
+	return m.events[i:]
+}
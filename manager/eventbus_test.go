@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+func TestStreamEvents(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	a := &API{Manager: m}
+	a.initRouter()
+	srv := httptest.NewServer(a.Router)
+	defer srv.Close()
+
+	watched := &task.Task{ID: uuid.New(), State: task.Pending}
+	other := &task.Task{ID: uuid.New(), State: task.Pending}
+	m.mu.Lock()
+	m.setStateLocked(watched, task.Scheduled, SourceScheduler, "")
+	m.setStateLocked(watched, task.Running, SourceWorker, "")
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream?task="+watched.ID.String(), nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events/stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	m.mu.Lock()
+	m.setStateLocked(other, task.Scheduled, SourceScheduler, "")
+	m.setStateLocked(watched, task.Failed, SourceWorker, "exited")
+	m.mu.Unlock()
+
+	// The backlog after event 1, then the live event of the watched task.
+	var ids []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() && len(ids) < 2 {
+		if id, ok := strings.CutPrefix(sc.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	if strings.Join(ids, ",") != "2,4" {
+		t.Fatalf("streamed event IDs %v, want [2 4]", ids)
+	}
+}
@@ -0,0 +1,180 @@
+package manager
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// Event is an entry in the manager's timeline of what happened to tasks.
+// Events are numbered by Index in the order they were recorded.
+type Event struct {
+	Index  uint64
+	Time   time.Time
+	Type   EventType
+	Source EventSource
+	TaskID uuid.UUID
+	JobID  uuid.UUID
+	Worker string `json:",omitempty"`
+	// From and To are the states of an EventStateChanged event.
+	From   *task.State `json:",omitempty"`
+	To     *task.State `json:",omitempty"`
+	Reason string      `json:",omitempty"`
+}
+
+type EventType string
+
+const (
+	EventStateChanged EventType = "state-changed"
+	// EventScheduled records the worker a task was placed on.
+	EventScheduled         EventType = "scheduled"
+	EventHealthCheckFailed EventType = "health-check-failed"
+	EventRestarted         EventType = "restarted"
+	// EventWorkerDown and EventWorkerUp record a worker becoming
+	// unreachable and coming back. They have no TaskID.
+	EventWorkerDown EventType = "worker-down"
+	EventWorkerUp   EventType = "worker-up"
+)
+
+// EventSource is what caused an event.
+type EventSource string
+
+const (
+	SourceUser        EventSource = "user"
+	SourceManager     EventSource = "manager"
+	SourceScheduler   EventSource = "scheduler"
+	SourceWorker      EventSource = "worker"
+	SourceHealthCheck EventSource = "health-check"
+)
+
+const (
+	// maxEvents is how many events the manager keeps at least. Older ones
+	// are dropped in batches once twice as many have built up, so the
+	// cost of trimming is not paid on every event.
+	maxEvents = 10000
+
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// EventPage is one page of events. Pass Next as since to get the events
+// that follow.
+type EventPage struct {
+	Events []Event
+	Next   uint64
+	More   bool
+}
+
+// recordEventLocked stamps e with the next index and the current time and
+// appends it to the timeline. The caller must hold m.mu.
+func (m *Manager) recordEventLocked(e Event) {
+	m.eventIndex++
+	e.Index = m.eventIndex
+	e.Time = time.Now().UTC()
+	m.events = append(m.events, e)
+	if len(m.events) >= 2*maxEvents {
+		m.events = slices.Clone(m.events[len(m.events)-maxEvents:])
+	}
+	m.bus.publish(e)
+}
+
+// eventTypes are the types a client may filter on.
+var eventTypes = []EventType{EventStateChanged, EventScheduled, EventHealthCheckFailed, EventRestarted, EventWorkerDown, EventWorkerUp}
+
+// taskEventLocked records an event of the given type about t. The caller
+// must hold m.mu.
+func (m *Manager) taskEventLocked(t *task.Task, typ EventType, source EventSource, reason string) {
+	m.recordEventLocked(Event{
+		Type:   typ,
+		Source: source,
+		TaskID: t.ID,
+		JobID:  t.JobID,
+		Worker: m.TaskWorkerMap[t.ID],
+		Reason: reason,
+	})
+}
+
+// setStateLocked moves t to state and records the change, unless the
+// transition is not a valid one. It reports whether t is now in state. The
+// caller must hold m.mu.
+func (m *Manager) setStateLocked(t *task.Task, state task.State, source EventSource, reason string) bool {
+	if t.State == state {
+		return true
+	}
+	if !task.ValidStateTransition(t.State, state) {
+		logger.Warn("ignoring invalid state transition", "task_id", t.ID, "from", t.State, "to", state)
+		return false
+	}
+	m.moveStateLocked(t, state, source, reason)
+	return true
+}
+
+// moveStateLocked moves t to state and records the change without checking
+// the transition, for worker reports that may skip states the manager never
+// saw. The caller must hold m.mu.
+func (m *Manager) moveStateLocked(t *task.Task, state task.State, source EventSource, reason string) {
+	from := t.State
+	m.recordEventLocked(Event{
+		Type:   EventStateChanged,
+		Source: source,
+		TaskID: t.ID,
+		JobID:  t.JobID,
+		Worker: m.TaskWorkerMap[t.ID],
+		From:   &from,
+		To:     &state,
+		Reason: reason,
+	})
+	t.State = state
+}
+
+// setWorkerDownLocked records a worker becoming unreachable or reachable
+// again. The caller must hold m.mu.
+func (m *Manager) setWorkerDownLocked(worker string, down bool) {
+	if m.down[worker] == down {
+		return
+	}
+	m.down[worker] = down
+	typ := EventWorkerUp
+	if down {
+		typ = EventWorkerDown
+	}
+	m.recordEventLocked(Event{Type: typ, Source: SourceManager, Worker: worker})
+}
+
+// GetEvents returns up to limit events recorded after since, oldest first,
+// optionally only those of one task. A limit of 0 means the default.
+func (m *Manager) GetEvents(taskID uuid.UUID, since uint64, limit int) (EventPage, error) {
+	if limit <= 0 {
+		limit = defaultEventLimit
+	}
+	limit = min(limit, maxEventLimit)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.TaskDB[taskID]; taskID != uuid.Nil && !ok {
+		return EventPage{}, fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+	}
+
+	f := EventFilter{TaskID: taskID}
+	page := EventPage{Events: []Event{}, Next: since}
+	for _, e := range m.eventsSinceLocked(since) {
+		if !f.Matches(e) {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.More = true
+			break
+		}
+		page.Events = append(page.Events, e)
+		page.Next = e.Index
+	}
+	// With nothing left to return, skip past the other tasks' events too.
+	if !page.More && len(m.events) > 0 {
+		page.Next = max(page.Next, m.events[len(m.events)-1].Index)
+	}
+	return page, nil
+}
@@ -2,10 +2,12 @@ package manager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"strconv"
 
+	"github.com/ctfrancia/mongeta/jobspec"
 	"github.com/ctfrancia/mongeta/logger"
 	"github.com/ctfrancia/mongeta/task"
 	"github.com/go-chi/chi/v5"
@@ -13,6 +15,11 @@ import (
 )
 
 func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	if isYAML(r) {
+		a.startTaskFromSpec(w, r)
+		return
+	}
+
 	d := json.NewDecoder(r.Body)
 	d.DisallowUnknownFields()
 
@@ -28,13 +35,76 @@ func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(e)
 		return
 	}
+	if errs := jobspec.ValidateTaskEvent(te); len(errs) > 0 {
+		writeValidationError(w, errs)
+		return
+	}
 
-	a.Manager.AddTask(te)
+	// A retry of a submission gets the task back rather than a duplicate.
+	t, created, err := a.Manager.SubmitTaskEvent(te)
+	if err != nil {
+		a.writeQueueError(w, err)
+		return
+	}
+	if !created {
+		if templateChanged(t, te.Task) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("task %s already exists with a different spec", t.ID))
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+		return
+	}
 	logger.Info("added task to manager", "task_id", te.Task.ID)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(te.Task)
 }
 
+// SubmitTaskEvent records the task of a start event posted by a client as
+// Pending and queues the event. If a task with its ID is already known,
+// nothing is queued and a copy of that task is returned with false. If the
+// event cannot be queued, the task is forgotten again so the client can
+// retry.
+func (m *Manager) SubmitTaskEvent(te task.TaskEvent) (task.Task, bool, error) {
+	m.mu.Lock()
+	if stored, ok := m.TaskDB[te.Task.ID]; ok {
+		t := *stored
+		m.mu.Unlock()
+		return t, false, nil
+	}
+	t := te.Task
+	m.TaskDB[t.ID] = &t
+	m.mu.Unlock()
+
+	if err := m.AddTask(te); err != nil {
+		m.mu.Lock()
+		delete(m.TaskDB, t.ID)
+		m.mu.Unlock()
+		return task.Task{}, false, err
+	}
+	return te.Task, true, nil
+}
+
+// startTaskFromSpec submits the single task described by a YAML job spec.
+// The manager assigns its ID and initial state.
+func (a *API) startTaskFromSpec(w http.ResponseWriter, r *http.Request) {
+	spec, err := jobspec.Parse(r.Body)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if spec.Type != jobspec.TypeTask {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("spec of type %q must be submitted to /jobs", spec.Type))
+		return
+	}
+
+	t, err := a.Manager.SubmitTask(spec.ToTask())
+	if err != nil {
+		a.writeQueueError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, t)
+}
+
 func (m *Manager) GetTasks() []*task.Task {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -45,10 +115,63 @@ func (m *Manager) GetTasks() []*task.Task {
 	return tasks
 }
 
+// QueryTasks returns copies of the tasks q selects, optionally only those
+// placed on worker, and the cursor of the next page.
+func (m *Manager) QueryTasks(q task.Query, worker string) ([]task.Task, string, error) {
+	m.mu.RLock()
+	tasks := make([]task.Task, 0, len(m.TaskDB))
+	for _, t := range m.TaskDB {
+		if worker == "" || m.TaskWorkerMap[t.ID] == worker {
+			tasks = append(tasks, *t)
+		}
+	}
+	m.mu.RUnlock()
+	return q.Apply(tasks)
+}
+
+// GetTask returns a copy of the task with the given ID.
+func (m *Manager) GetTask(id uuid.UUID) (task.Task, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.TaskDB[id]
+	if !ok {
+		return task.Task{}, fmt.Errorf("%w: %s", ErrTaskNotFound, id)
+	}
+	return *t, nil
+}
+
+// GetTasksHandler lists tasks, filtered by the state, name, image, selector
+// and worker query parameters and ordered by sort. With a limit, the cursor of the
+// next page is sent in the X-Next-Cursor header.
 func (a *API) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(a.Manager.GetTasks())
+	q, err := task.ParseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tasks, next, err := a.Manager.QueryTasks(q, r.URL.Query().Get("worker"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+func (a *API) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task ID: %v", err))
+		return
+	}
+	t, err := a.Manager.GetTask(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
 }
 
 func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -75,17 +198,107 @@ func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		TimeStamp: time.Now(),
-	}
-
-	taskCopy := *taskToStop
-	taskCopy.State = task.Completed
-	te.Task = taskCopy
-	a.Manager.AddTask(te)
+	a.Manager.stopTask(*taskToStop, SourceUser, "stopped by user")
 
 	logger.Info("stopping task", "task_id", taskToStop.ID)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// StopTasks stops every task whose labels match sel and that has not
+// finished or begun stopping, and returns them. Tasks of a job are
+// replaced unless the job is scaled down too.
+func (m *Manager) StopTasks(sel task.Selector) []task.Task {
+	m.mu.RLock()
+	matched := []task.Task{}
+	for _, t := range m.TaskDB {
+		switch t.State {
+		case task.Completed, task.Failed, task.Stopping:
+			continue
+		}
+		if sel.Matches(t.Labels) {
+			matched = append(matched, *t)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, t := range matched {
+		m.stopTask(t, SourceUser, "stopped by selector")
+	}
+	return matched
+}
+
+// StopTasksHandler stops the tasks matching the selector query parameter,
+// which must not be empty.
+func (a *API) StopTasksHandler(w http.ResponseWriter, r *http.Request) {
+	sel, err := task.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(sel) == 0 {
+		writeError(w, http.StatusBadRequest, "a selector is required to stop tasks in bulk")
+		return
+	}
+	stopped := a.Manager.StopTasks(sel)
+	logger.Info("stopping tasks by selector", "selector", r.URL.Query().Get("selector"), "count", len(stopped))
+	writeJSON(w, http.StatusOK, stopped)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, ErrResponse{
+		HTTPStatusCode: status,
+		Message:        msg,
+	})
+}
+
+// writeQueueError writes a 429 if a task was turned away because the
+// queues are full, or a 503 if it could not be queued at all, telling the
+// client when to retry.
+func (a *API) writeQueueError(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(a.Manager.RetryAfter.Seconds(), 1))))
+	if errors.Is(err, ErrQueueFull) {
+		writeError(w, http.StatusTooManyRequests, "manager queue is full, retry later")
+		return
+	}
+	writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("cannot queue task: %v", err))
+}
+
+// writeValidationError writes a 400 for err, listing its fields if it is
+// a jobspec.FieldErrors.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var fe jobspec.FieldErrors
+	if !errors.As(err, &fe) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusBadRequest, ErrResponse{
+		HTTPStatusCode: http.StatusBadRequest,
+		Message:        fe.Error(),
+		Errors:         fe,
+	})
+}
+
+// GetTaskAllocationsHandler lists every attempt at running a task.
+func (a *API) GetTaskAllocationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task ID: %v", err))
+		return
+	}
+	allocs, err := a.Manager.GetAllocations(id)
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, allocs)
+}
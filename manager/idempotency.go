@@ -0,0 +1,174 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long the manager remembers the response to a
+// request sent with an Idempotency-Key, unless Manager.IdempotencyTTL says
+// otherwise.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKey is the longest Idempotency-Key accepted.
+const maxIdempotencyKey = 255
+
+// idempotencyClaimTTL is how long a key stays claimed by a request that
+// has not finished, such as one whose handler hung. After that a retry is
+// handled afresh.
+const idempotencyClaimTTL = time.Minute
+
+// idempotencySweepInterval is how often expired keys are forgotten.
+const idempotencySweepInterval = time.Minute
+
+// idempotencyStore holds the responses to requests with an
+// Idempotency-Key. It has its own lock so that replays do not contend with
+// the rest of the manager.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	lastSweep time.Time
+}
+
+// idempotentResponse is the response to a request with an Idempotency-Key,
+// replayed to retries of the same request.
+type idempotentResponse struct {
+	// fingerprint is a hash of the request, so a key reused for a
+	// different request is caught.
+	fingerprint [sha256.Size]byte
+	// done is false while the first request is still being handled.
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	// expires is when the response, or the claim of a request not yet
+	// done, is forgotten.
+	expires time.Time
+}
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent makes next safe to retry: a request carrying an
+// Idempotency-Key gets the response of the first request with that key,
+// for as long as the manager remembers it. Server errors and 429s are not
+// kept, so the request can be retried after one.
+func (a *API) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKey))
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("error reading request: %v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(fmt.Appendf(nil, "%s %s %s\n%s", r.Method, r.URL.Path, r.Header.Get("Content-Type"), body))
+
+		prev, first := a.Manager.claimIdempotencyKey(key, fingerprint)
+		switch {
+		case first:
+		case prev.fingerprint != fingerprint:
+			writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			return
+		case !prev.done:
+			writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+			return
+		default:
+			if prev.contentType != "" {
+				w.Header().Set("Content-Type", prev.contentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prev.status)
+			w.Write(prev.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		finished := false
+		defer func() {
+			// Release the key if next panicked.
+			if !finished {
+				a.Manager.finishIdempotencyKey(key, http.StatusInternalServerError, "", nil)
+			}
+		}()
+		next(rec, r)
+		finished = true
+		a.Manager.finishIdempotencyKey(key, max(rec.status, http.StatusOK), w.Header().Get("Content-Type"), rec.body.Bytes())
+	}
+}
+
+// claimIdempotencyKey returns the response recorded for key, or claims the
+// key for a new request and returns true. An expired key is claimed afresh.
+func (m *Manager) claimIdempotencyKey(key string, fingerprint [sha256.Size]byte) (idempotentResponse, bool) {
+	s := m.idempotency
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= idempotencySweepInterval {
+		for k, resp := range s.responses {
+			if now.After(resp.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if resp, ok := s.responses[key]; ok && !now.After(resp.expires) {
+		return *resp, false
+	}
+	s.responses[key] = &idempotentResponse{fingerprint: fingerprint, expires: now.Add(idempotencyClaimTTL)}
+	return idempotentResponse{}, true
+}
+
+// finishIdempotencyKey records the response to the request that claimed
+// key, or releases the key if it failed with a server error or was turned
+// away for now.
+func (m *Manager) finishIdempotencyKey(key string, status int, contentType string, body []byte) {
+	s := m.idempotency
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp, ok := s.responses[key]
+	if !ok {
+		return
+	}
+	if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+		delete(s.responses, key)
+		return
+	}
+	resp.done = true
+	resp.status = status
+	resp.contentType = contentType
+	resp.body = bytes.Clone(body)
+	resp.expires = time.Now().Add(m.IdempotencyTTL)
+}
@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestIdempotentSubmission(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	a := &API{Manager: m}
+	a.initRouter()
+	srv := httptest.NewServer(a.Router)
+	defer srv.Close()
+
+	taskID := uuid.New()
+	body := func(image string) string {
+		return `{"ID":"` + uuid.NewString() + `","State":"Scheduled","Task":{"ID":"` + taskID.String() + `","Image":"` + image + `"}}`
+	}
+	post := func(key, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/tasks", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /tasks: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	first := body("strm/helloworld-http")
+	if resp := post("k1", first); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first submission: status %d", resp.StatusCode)
+	}
+	if resp := post("k1", first); resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: status %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	if resp := post("k1", body("nginx")); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused for another request: status %d", resp.StatusCode)
+	}
+	// Without a key, the task ID alone identifies a retry.
+	if resp := post("", body("strm/helloworld-http")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("resubmitted task ID: status %d", resp.StatusCode)
+	}
+	if resp := post("", body("nginx")); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("task ID reused with another spec: status %d", resp.StatusCode)
+	}
+	if n := len(m.Pending); n != 1 {
+		t.Fatalf("%d events queued, want 1", n)
+	}
+}
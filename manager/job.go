@@ -0,0 +1,489 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// JobType distinguishes the kinds of workload a Job describes.
+type JobType string
+
+const (
+	// JobTypeService keeps Replicas copies of the template task running.
+	JobTypeService JobType = "service"
+	// JobTypeBatch runs the template task to completion Completions times.
+	JobTypeBatch JobType = "batch"
+)
+
+// JobState is the overall outcome of a job. Services stay JobRunning until
+// deleted; batch jobs end as JobSucceeded or JobFailed.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// Job is a desired-state workload owned by the manager. The reconciler
+// compares it against the tasks in TaskDB and creates or stops tasks until
+// they match.
+type Job struct {
+	ID         uuid.UUID
+	Name       string
+	Type       JobType
+	Version    int
+	Replicas   int
+	Template   task.Task
+	Update     UpdateStrategy
+	Status     JobStatus
+	CreateTime time.Time
+	UpdateTime time.Time
+
+	// ModifyIndex changes whenever the job's spec or version does. A plan
+	// returns it as its check index.
+	ModifyIndex uint64
+
+	// Completions is how many tasks of a batch job must exit 0, Parallelism
+	// how many may run at once and BackoffLimit how many failed tasks are
+	// retried before the job fails.
+	Completions  int
+	Parallelism  int
+	BackoffLimit int
+
+	// Periodic makes a batch job launch a child run on a cron schedule.
+	// ParentID links such a run back to the job that launched it.
+	Periodic *PeriodicConfig
+	ParentID uuid.UUID
+}
+
+// JobStatus is the observed state of a job's tasks as of the last
+// reconciliation pass.
+type JobStatus struct {
+	State          JobState
+	Reason         string
+	Pending        int
+	Running        int
+	Stopping       int
+	Lost           int
+	Completed      int
+	Failed         int
+	CompletionTime time.Time
+
+	// LastScheduleTime is the scheduled time of the latest periodic run
+	// that was launched or skipped.
+	LastScheduleTime time.Time
+}
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrInvalidJobSpec = errors.New("invalid job spec")
+)
+
+func validateJob(j *Job) error {
+	if strings.TrimSpace(j.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidJobSpec)
+	}
+	switch j.Type {
+	case JobTypeService, JobTypeBatch:
+	default:
+		return fmt.Errorf("%w: unknown job type %q", ErrInvalidJobSpec, j.Type)
+	}
+	if j.Replicas < 0 {
+		return fmt.Errorf("%w: replicas must not be negative", ErrInvalidJobSpec)
+	}
+	if j.Completions < 0 || j.Parallelism < 0 || j.BackoffLimit < 0 {
+		return fmt.Errorf("%w: completions, parallelism and backoff limit must not be negative", ErrInvalidJobSpec)
+	}
+	if strings.TrimSpace(j.Template.Image) == "" {
+		return fmt.Errorf("%w: template image is required", ErrInvalidJobSpec)
+	}
+	if err := task.Validate(j.Template); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJobSpec, err)
+	}
+	if err := validatePeriodic(j); err != nil {
+		return err
+	}
+	return validateStrategy(j.Update)
+}
+
+// setJobDefaults fills in the fields of a validated spec that were left
+// unset.
+func setJobDefaults(j *Job) {
+	j.Update = j.Update.withDefaults()
+	if j.Type == JobTypeBatch {
+		j.Completions = max(j.Completions, 1)
+		j.Parallelism = max(j.Parallelism, 1)
+	}
+	if j.Periodic != nil {
+		p := *j.Periodic
+		p.withDefaults()
+		j.Periodic = &p
+	}
+}
+
+// AddJob validates j, assigns it an ID and stores it in JobDB. Its tasks are
+// created on the next reconciliation pass.
+func (m *Manager) AddJob(j Job) (*Job, error) {
+	if j.Type == "" {
+		j.Type = JobTypeService
+	}
+	if err := validateJob(&j); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	j.ID = uuid.New()
+	j.Version = 0
+	setJobDefaults(&j)
+	j.Status = JobStatus{State: JobRunning}
+	j.CreateTime = now
+	j.UpdateTime = now
+
+	m.mu.Lock()
+	m.JobDB[j.ID] = &j
+	m.touchLocked(&j)
+	m.recordVersionLocked(&j)
+	m.mu.Unlock()
+
+	logger.Info("added job", "job_id", j.ID, "name", j.Name, "replicas", j.Replicas)
+	c := j
+	return &c, nil
+}
+
+// GetJob returns a copy of the job with the given ID.
+func (m *Manager) GetJob(id uuid.UUID) (*Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, ok := m.JobDB[id]
+	if !ok {
+		return nil, false
+	}
+	c := *j
+	return &c, true
+}
+
+// GetJobs returns copies of all jobs, optionally restricted to one type.
+func (m *Manager) GetJobs(jobType JobType) []*Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	jobs := make([]*Job, 0, len(m.JobDB))
+	for _, j := range m.JobDB {
+		if jobType != "" && j.Type != jobType {
+			continue
+		}
+		c := *j
+		jobs = append(jobs, &c)
+	}
+	return jobs
+}
+
+// ScaleJob changes the desired replica count of a job. The new count is
+// recorded as a new version without replacing any tasks.
+func (m *Manager) ScaleJob(id uuid.UUID, replicas int) (*Job, error) {
+	if replicas < 0 {
+		return nil, fmt.Errorf("%w: replicas must not be negative", ErrInvalidJobSpec)
+	}
+
+	m.mu.Lock()
+	j, ok := m.JobDB[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrJobNotFound
+	}
+	if j.Type != JobTypeService {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: only services can be scaled", ErrInvalidJobSpec)
+	}
+	if j.Replicas != replicas {
+		spec := *j
+		spec.Replicas = replicas
+		m.applySpecLocked(j, spec)
+	}
+	c := *j
+	m.mu.Unlock()
+
+	logger.Info("scaled job", "job_id", id, "replicas", replicas)
+	return &c, nil
+}
+
+// DeleteJob removes a job and stops all of its live tasks. Deleting a
+// periodic job deletes its runs as well.
+func (m *Manager) DeleteJob(id uuid.UUID) error {
+	m.mu.Lock()
+	_, ok := m.JobDB[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrJobNotFound
+	}
+	delete(m.JobDB, id)
+	delete(m.VersionDB, id)
+	m.mu.Unlock()
+
+	for _, run := range m.runs(id) {
+		m.DeleteJob(run.ID)
+	}
+
+	for _, t := range m.liveTasks(id) {
+		m.stopTask(*t, SourceManager, "job deleted")
+	}
+	logger.Info("deleted job", "job_id", id)
+	return nil
+}
+
+// newTask builds a fresh task from the job's template.
+func (j *Job) newTask() task.Task {
+	t := j.Template
+	t.ID = uuid.New()
+	t.JobID = j.ID
+	t.JobVersion = j.Version
+	t.Name = fmt.Sprintf("%s-%s", j.Name, t.ID.String()[:8])
+	t.State = task.Pending
+	t.ContainerID = ""
+	t.HostPorts = nil
+	t.PortBindings = nil
+	t.AllocID = uuid.Nil
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.RestartCount = 0
+	return t
+}
+
+// isLive reports whether t counts towards its job's replicas: it is placed or
+// about to be, or it has failed or been lost on a worker but will still be
+// restarted. The caller must hold m.mu.
+func (m *Manager) isLive(t *task.Task) bool {
+	switch t.State {
+	case task.Pending, task.Scheduled, task.Running, task.Restarting, task.Unknown:
+		return true
+	case task.Failed, task.Lost:
+		_, dispatched := m.TaskWorkerMap[t.ID]
+		return dispatched && t.RestartCount < m.MaxRestarts
+	default:
+		return false
+	}
+}
+
+// progress orders the live states by how far a task has got. Tasks that
+// have failed, been lost or are in doubt rank lowest, as they are not doing
+// any work until restarted.
+var progress = map[task.State]int{
+	task.Failed:     0,
+	task.Lost:       0,
+	task.Unknown:    0,
+	task.Pending:    1,
+	task.Scheduled:  2,
+	task.Restarting: 2,
+	task.Running:    3,
+}
+
+// liveTasks returns the live tasks of a job, most progressed first, so that
+// scaling down stops broken and unplaced tasks before healthy running ones.
+func (m *Manager) liveTasks(jobID uuid.UUID) []*task.Task {
+	m.mu.RLock()
+	var tasks []*task.Task
+	for _, t := range m.TaskDB {
+		if t.JobID == jobID && m.isLive(t) {
+			c := *t
+			tasks = append(tasks, &c)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(tasks, func(a, b *task.Task) int {
+		if pa, pb := progress[a.State], progress[b.State]; pa != pb {
+			return pb - pa
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return tasks
+}
+
+// jobTasks returns copies of every task ever created for a job.
+func (m *Manager) jobTasks(jobID uuid.UUID) []*task.Task {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var tasks []*task.Task
+	for _, t := range m.TaskDB {
+		if t.JobID == jobID {
+			c := *t
+			tasks = append(tasks, &c)
+		}
+	}
+	return tasks
+}
+
+// isBatchTask reports whether t was created by a batch job.
+func (m *Manager) isBatchTask(t *task.Task) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, ok := m.JobDB[t.JobID]
+	return ok && j.Type == JobTypeBatch
+}
+
+// submitTask records t as Pending in TaskDB and queues it for scheduling.
+// If the queue is full, t is failed so its job or workflow sees it.
+func (m *Manager) submitTask(t task.Task) error {
+	t.State = task.Pending
+	m.mu.Lock()
+	if t.JobID != uuid.Nil {
+		t.AllocIndex = m.nextAllocIndexLocked(t.JobID)
+	}
+	m.TaskDB[t.ID] = &t
+	m.mu.Unlock()
+
+	err := m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		TimeStamp: time.Now(),
+		Task:      t,
+	})
+	if err != nil {
+		m.mu.Lock()
+		m.failQueuedLocked(t, task.ReasonQueueFull)
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// nextAllocIndexLocked returns the lowest AllocIndex not held by a live
+// task of the job. The caller must hold m.mu.
+func (m *Manager) nextAllocIndexLocked(jobID uuid.UUID) int {
+	used := make(map[int]bool)
+	for _, t := range m.TaskDB {
+		if t.JobID == jobID && m.isLive(t) {
+			used[t.AllocIndex] = true
+		}
+	}
+	i := 0
+	for used[i] {
+		i++
+	}
+	return i
+}
+
+// stopTask queues a stop event for t and marks it Stopping. Tasks that were
+// never dispatched are marked Completed straight away so SendWork drops their
+// start event. A task that has already finished is left alone.
+func (m *Manager) stopTask(t task.Task, source EventSource, reason string) {
+	if _, ok := m.GetTaskWorker(t.ID); !ok {
+		m.mu.Lock()
+		if stored, ok := m.TaskDB[t.ID]; ok && m.setStateLocked(stored, task.Completed, source, reason) {
+			stored.FinishTime = time.Now().UTC()
+		}
+		m.mu.Unlock()
+		return
+	}
+
+	// The task stops counting towards its job straight away, while the
+	// worker gives it its grace period.
+	m.mu.Lock()
+	stored, ok := m.TaskDB[t.ID]
+	stopping := ok && stored.State != task.Stopping && m.setStateLocked(stored, task.Stopping, source, reason)
+	m.mu.Unlock()
+	if !stopping {
+		return
+	}
+
+	t.State = task.Completed
+	err := m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		TimeStamp: time.Now(),
+		Task:      t,
+	})
+	if err != nil {
+		logger.Error("cannot queue stop event", "task_id", t.ID, "err", err)
+	}
+}
+
+func (m *Manager) ReconcileJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logger.Info("reconciling jobs")
+			m.reconcileJobs()
+		}
+	}
+}
+
+func (m *Manager) reconcileJobs() {
+	for _, j := range m.GetJobs("") {
+		m.reconcileJob(j)
+	}
+}
+
+func (m *Manager) reconcileJob(j *Job) {
+	if j.Type == JobTypeBatch {
+		m.reconcileBatchJob(j)
+		m.updateJobStatus(j.ID)
+		return
+	}
+	if d, ok := m.activeDeployment(j.ID); ok {
+		m.progressDeployment(j, d)
+		m.updateJobStatus(j.ID)
+		return
+	}
+
+	live := m.liveTasks(j.ID)
+
+	switch {
+	case len(live) < j.Replicas:
+		missing := j.Replicas - len(live)
+		logger.Info("job under-replicated, creating tasks", "job_id", j.ID, "live", len(live), "desired", j.Replicas)
+		for range missing {
+			m.submitTask(j.newTask())
+		}
+	case len(live) > j.Replicas:
+		logger.Info("job over-replicated, stopping tasks", "job_id", j.ID, "live", len(live), "desired", j.Replicas)
+		for _, t := range live[j.Replicas:] {
+			m.stopTask(*t, SourceManager, "job over-replicated")
+		}
+	}
+
+	m.updateJobStatus(j.ID)
+}
+
+func (m *Manager) updateJobStatus(jobID uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.JobDB[jobID]
+	if !ok {
+		return
+	}
+
+	s := j.Status
+	s.Pending, s.Running, s.Stopping, s.Lost, s.Completed, s.Failed = 0, 0, 0, 0, 0, 0
+	for _, t := range m.TaskDB {
+		if t.JobID != jobID {
+			continue
+		}
+		switch t.State {
+		case task.Pending, task.Scheduled, task.Restarting:
+			s.Pending++
+		case task.Running:
+			s.Running++
+		case task.Stopping:
+			s.Stopping++
+		case task.Lost, task.Unknown:
+			s.Lost++
+		case task.Completed:
+			s.Completed++
+		case task.Failed:
+			s.Failed++
+		}
+	}
+	j.Status = s
+}
@@ -0,0 +1,444 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ctfrancia/mongeta/jobspec"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type scaleRequest struct {
+	Replicas *int
+}
+
+type versionDiff struct {
+	From int
+	To   int
+	Diff []FieldDiff
+}
+
+func jobIDParam(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "jobID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job ID: %v", err))
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrJobNotFound), errors.Is(err, ErrVersionNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidJobSpec):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrCheckIndexMismatch):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (a *API) CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	j := Job{}
+	if err := d.Decode(&j); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling service: %v", err))
+		return
+	}
+	j.Type = JobTypeService
+
+	created, err := a.Manager.AddJob(j)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (a *API) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.GetJobs(JobTypeService))
+}
+
+func (a *API) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	j, ok := a.Manager.GetJob(id)
+	if !ok || j.Type != JobTypeService {
+		writeJobError(w, ErrJobNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (a *API) ScaleServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := scaleRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling scale request: %v", err))
+		return
+	}
+	if req.Replicas == nil {
+		writeError(w, http.StatusBadRequest, "Replicas is required")
+		return
+	}
+
+	j, err := a.Manager.ScaleJob(id, *req.Replicas)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (a *API) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Manager.DeleteJob(id); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	spec := Job{}
+	if err := d.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling service: %v", err))
+		return
+	}
+
+	j, err := a.Manager.UpdateJob(id, spec)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (a *API) GetServiceDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := a.Manager.GetJob(id); !ok {
+		writeJobError(w, ErrJobNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Manager.GetDeployments(id))
+}
+
+func (a *API) GetDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.GetDeployments(uuid.Nil))
+}
+
+func deploymentIDParam(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "deploymentID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid deployment ID: %v", err))
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeDeploymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrDeploymentNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrDeploymentNotActive), errors.Is(err, ErrDeploymentNotReady):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (a *API) GetDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deploymentIDParam(w, r)
+	if !ok {
+		return
+	}
+	d, ok := a.Manager.GetDeployment(id)
+	if !ok {
+		writeDeploymentError(w, ErrDeploymentNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (a *API) PromoteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deploymentIDParam(w, r)
+	if !ok {
+		return
+	}
+	d, err := a.Manager.PromoteDeployment(id)
+	if err != nil {
+		writeDeploymentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (a *API) AbortDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deploymentIDParam(w, r)
+	if !ok {
+		return
+	}
+	d, err := a.Manager.AbortDeployment(id)
+	if err != nil {
+		writeDeploymentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// decodeJob reads a job from a YAML spec or a JSON Job, writing the error
+// response itself if the body is invalid.
+func decodeJob(w http.ResponseWriter, r *http.Request) (Job, bool) {
+	j := Job{}
+	if isYAML(r) {
+		spec, err := jobspec.Parse(r.Body)
+		if err != nil {
+			writeValidationError(w, err)
+			return j, false
+		}
+		if j, err = jobFromSpec(spec); err != nil {
+			writeJobError(w, err)
+			return j, false
+		}
+		return j, true
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&j); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling job: %v", err))
+		return j, false
+	}
+	if j.Type == "" {
+		j.Type = JobTypeBatch
+	}
+	return j, true
+}
+
+func (a *API) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := decodeJob(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := a.Manager.AddJob(j)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (a *API) GetJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.GetJobs(JobType(r.URL.Query().Get("type"))))
+}
+
+func (a *API) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	j, ok := a.Manager.GetJob(id)
+	if !ok {
+		writeJobError(w, ErrJobNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (a *API) DeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Manager.DeleteJob(id); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) TriggerJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	run, err := a.Manager.TriggerJob(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, run)
+}
+
+// versionParam reads a version number from the query string. A missing
+// parameter yields def.
+func versionParam(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s: %q", name, v))
+		return 0, false
+	}
+	return n, true
+}
+
+func (a *API) GetJobVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	versions, err := a.Manager.GetJobVersions(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// GetJobDiffHandler compares two versions of a job. "to" defaults to the
+// current version and "from" to the version before it.
+func (a *API) GetJobDiffHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	versions, err := a.Manager.GetJobVersions(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	current := versions[len(versions)-1].Version
+	to, ok := versionParam(w, r, "to", current)
+	if !ok {
+		return
+	}
+	prev := to
+	for _, v := range versions {
+		if v.Version < to {
+			prev = v.Version
+		}
+	}
+	from, ok := versionParam(w, r, "from", prev)
+	if !ok {
+		return
+	}
+
+	fv, err := a.Manager.GetJobVersion(id, from)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	tv, err := a.Manager.GetJobVersion(id, to)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, versionDiff{From: from, To: to, Diff: DiffJobs(fv.Spec, tv.Spec)})
+}
+
+func (a *API) RevertJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("version") == "" {
+		writeError(w, http.StatusBadRequest, "version is required")
+		return
+	}
+	version, ok := versionParam(w, r, "version", 0)
+	if !ok {
+		return
+	}
+	j, err := a.Manager.RevertJob(id, version)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (a *API) PlanJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	spec, ok := decodeJob(w, r)
+	if !ok {
+		return
+	}
+	p, err := a.Manager.PlanJob(id, spec)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// UpdateJobHandler applies a spec to a job. With a check_index parameter
+// the update is refused if the job changed since the plan that returned it.
+func (a *API) UpdateJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobIDParam(w, r)
+	if !ok {
+		return
+	}
+	spec, ok := decodeJob(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		j   *Job
+		err error
+	)
+	if v := r.URL.Query().Get("check_index"); v != "" {
+		index, perr := strconv.ParseUint(v, 10, 64)
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid check_index: %q", v))
+			return
+		}
+		j, err = a.Manager.ApplyJob(id, spec, index)
+	} else {
+		j, err = a.Manager.UpdateJob(id, spec)
+	}
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ctfrancia/mongeta/task"
+)
+
+func newTestJob(t *testing.T, m *Manager, replicas int) *Job {
+	t.Helper()
+	j, err := m.AddJob(Job{
+		Name:     "web",
+		Replicas: replicas,
+		Template: task.Task{Image: "strm/helloworld-http"},
+	})
+	if err != nil {
+		t.Fatalf("AddJob: unexpected error: %v", err)
+	}
+	return j
+}
+
+func TestAddJobValidation(t *testing.T) {
+	m := New([]string{"w1:8080"}, 10, 3)
+	tests := []Job{
+		{Replicas: 1, Template: task.Task{Image: "nginx"}},
+		{Name: "web", Replicas: -1, Template: task.Task{Image: "nginx"}},
+		{Name: "web", Replicas: 1},
+	}
+	for _, j := range tests {
+		if _, err := m.AddJob(j); !errors.Is(err, ErrInvalidJobSpec) {
+			t.Errorf("AddJob(%+v) error = %v, want ErrInvalidJobSpec", j, err)
+		}
+	}
+}
+
+func TestReconcileJobScalesUp(t *testing.T) {
+	m := New([]string{"w1:8080"}, 10, 3)
+	j := newTestJob(t, m, 3)
+
+	m.reconcileJobs()
+	if got := len(m.liveTasks(j.ID)); got != 3 {
+		t.Fatalf("live tasks = %d, want 3", got)
+	}
+	if got := len(m.Pending); got != 3 {
+		t.Errorf("queued events = %d, want 3", got)
+	}
+
+	// A second pass must not create duplicates.
+	m.reconcileJobs()
+	if got := len(m.liveTasks(j.ID)); got != 3 {
+		t.Errorf("live tasks after second pass = %d, want 3", got)
+	}
+
+	stored, _ := m.GetJob(j.ID)
+	if stored.Status.Pending != 3 {
+		t.Errorf("Status.Pending = %d, want 3", stored.Status.Pending)
+	}
+}
+
+func TestReconcileJobScalesDown(t *testing.T) {
+	m := New([]string{"w1:8080"}, 10, 3)
+	j := newTestJob(t, m, 3)
+	m.reconcileJobs()
+
+	if _, err := m.ScaleJob(j.ID, 1); err != nil {
+		t.Fatalf("ScaleJob: unexpected error: %v", err)
+	}
+	m.reconcileJobs()
+
+	if got := len(m.liveTasks(j.ID)); got != 1 {
+		t.Errorf("live tasks = %d, want 1", got)
+	}
+}
+
+func TestReconcileJobScalesDownKeepsRunning(t *testing.T) {
+	m := New([]string{"w1:8080"}, 10, 3)
+	j := newTestJob(t, m, 3)
+	m.reconcileJobs()
+
+	// One replica runs, one was lost with its worker and one is still
+	// waiting to be placed.
+	live := m.liveTasks(j.ID)
+	m.mu.Lock()
+	for i, state := range []task.State{task.Running, task.Lost} {
+		m.TaskDB[live[i].ID].State = state
+		m.TaskWorkerMap[live[i].ID] = "w1:8080"
+	}
+	m.mu.Unlock()
+	running := live[0].ID
+
+	if _, err := m.ScaleJob(j.ID, 1); err != nil {
+		t.Fatalf("ScaleJob: unexpected error: %v", err)
+	}
+	m.reconcileJobs()
+
+	live = m.liveTasks(j.ID)
+	if len(live) != 1 || live[0].ID != running || live[0].State != task.Running {
+		t.Errorf("live tasks = %v, want only the running task %s", live, running)
+	}
+}
+
+func TestDeleteJobStopsTasks(t *testing.T) {
+	m := New([]string{"w1:8080"}, 10, 3)
+	j := newTestJob(t, m, 2)
+	m.reconcileJobs()
+
+	if err := m.DeleteJob(j.ID); err != nil {
+		t.Fatalf("DeleteJob: unexpected error: %v", err)
+	}
+	if got := len(m.liveTasks(j.ID)); got != 0 {
+		t.Errorf("live tasks = %d, want 0", got)
+	}
+	if err := m.DeleteJob(j.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("second DeleteJob error = %v, want ErrJobNotFound", err)
+	}
+}
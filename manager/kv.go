@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrInvalidKey  = errors.New("invalid key")
+)
+
+var keyRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_./-]*$`)
+
+// PutKey sets a value in the KV store that task templates read from. It
+// reports whether the key is new.
+func (m *Manager) PutKey(key, value string) (bool, error) {
+	if !keyRe.MatchString(key) {
+		return false, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.KV[key]
+	m.KV[key] = value
+	return !ok, nil
+}
+
+func (m *Manager) GetKey(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.KV[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	return v, nil
+}
+
+// GetKeys returns a copy of the KV store.
+func (m *Manager) GetKeys() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	kv := make(map[string]string, len(m.KV))
+	for k, v := range m.KV {
+		kv[k] = v
+	}
+	return kv
+}
+
+func (m *Manager) DeleteKey(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.KV[key]; !ok {
+		return fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	delete(m.KV, key)
+	return nil
+}
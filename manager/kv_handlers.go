@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type keyValue struct {
+	Key   string
+	Value string
+}
+
+func writeKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidKey):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (a *API) GetKeysHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.GetKeys())
+}
+
+func (a *API) GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	v, err := a.Manager.GetKey(key)
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, keyValue{Key: key, Value: v})
+}
+
+// PutKeyHandler sets the key in the path to the Value in the body.
+func (a *API) PutKeyHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := keyValue{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling value: %v", err))
+		return
+	}
+
+	key := chi.URLParam(r, "*")
+	created, err := a.Manager.PutKey(key, req.Value)
+	if err != nil {
+		writeKeyError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, keyValue{Key: key, Value: req.Value})
+}
+
+func (a *API) DeleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Manager.DeleteKey(chi.URLParam(r, "*")); err != nil {
+		writeKeyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
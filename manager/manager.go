@@ -10,11 +10,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/secrets"
 	"github.com/ctfrancia/mongeta/task"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
@@ -24,12 +27,54 @@ type Manager struct {
 	Pending       chan task.TaskEvent
 	TaskDB        map[uuid.UUID]*task.Task
 	EventDB       map[uuid.UUID]*task.TaskEvent
+	JobDB         map[uuid.UUID]*Job
+	DeploymentDB  map[uuid.UUID]*Deployment
+	VersionDB     map[uuid.UUID][]JobVersion
+	AllocDB       map[uuid.UUID][]*Allocation
+	WorkflowDB    map[uuid.UUID]*Workflow
 	Workers       []string
 	WorkerTaskMap map[string][]uuid.UUID
 	TaskWorkerMap map[uuid.UUID]string
 	LastWorker    int
 	MaxRestarts   int
 	mu            sync.RWMutex
+	// health holds the result of the most recent health check per task.
+	health map[uuid.UUID]bool
+	// index is the last ModifyIndex handed out to a job.
+	index uint64
+	// deadlines holds the ScheduleDeadline timer of each queued task.
+	deadlines map[uuid.UUID]*time.Timer
+	// Secrets holds the values tasks reference by name. New gives the
+	// manager an in-memory store with a random key.
+	Secrets *secrets.Store
+	// KV holds plain values for task templates to read.
+	KV map[string]string
+	// templateInputs holds a hash of the secrets and keys each running
+	// task's templates were last rendered with.
+	templateInputs map[uuid.UUID]string
+	// events is the timeline of what happened to tasks, oldest first, and
+	// eventIndex the Index of the latest one.
+	events     []Event
+	eventIndex uint64
+	bus        *eventBus
+	// down holds the workers that could not be reached last time.
+	down map[string]bool
+	// queued holds the tasks with a start event in Pending, so the same
+	// task is not queued twice.
+	queued map[uuid.UUID]bool
+	// IdempotencyTTL is how long the response to a request with an
+	// Idempotency-Key is replayed to retries. idempotency holds them.
+	IdempotencyTTL time.Duration
+	idempotency    *idempotencyStore
+	// overflow holds the task events that did not fit in Pending; see
+	// SetOverflow. RetryAfter is how long clients are told to wait when
+	// both are full.
+	overflow   *overflowQueue
+	RetryAfter time.Duration
+	// rejected and overflowed count task events turned away and those
+	// that went to the overflow queue.
+	rejected   atomic.Uint64
+	overflowed atomic.Uint64
 }
 
 func New(workers []string, queueSize int, maxRestarts int) *Manager {
@@ -42,13 +87,30 @@ func New(workers []string, queueSize int, maxRestarts int) *Manager {
 	}
 
 	return &Manager{
-		Pending:       make(chan task.TaskEvent, queueSize),
-		TaskDB:        taskDB,
-		EventDB:       eventDB,
-		Workers:       workers,
-		WorkerTaskMap: workerTaskMap,
-		TaskWorkerMap: taskWorkerMap,
-		MaxRestarts:   maxRestarts,
+		Secrets:        secrets.NewMemory(),
+		Pending:        make(chan task.TaskEvent, queueSize),
+		TaskDB:         taskDB,
+		EventDB:        eventDB,
+		JobDB:          make(map[uuid.UUID]*Job),
+		DeploymentDB:   make(map[uuid.UUID]*Deployment),
+		VersionDB:      make(map[uuid.UUID][]JobVersion),
+		AllocDB:        make(map[uuid.UUID][]*Allocation),
+		WorkflowDB:     make(map[uuid.UUID]*Workflow),
+		health:         make(map[uuid.UUID]bool),
+		deadlines:      make(map[uuid.UUID]*time.Timer),
+		KV:             make(map[string]string),
+		templateInputs: make(map[uuid.UUID]string),
+		bus:            newEventBus(),
+		down:           make(map[string]bool),
+		queued:         make(map[uuid.UUID]bool),
+		IdempotencyTTL: DefaultIdempotencyTTL,
+		idempotency:    &idempotencyStore{responses: make(map[string]*idempotentResponse)},
+		overflow:       &overflowQueue{},
+		RetryAfter:     DefaultRetryAfter,
+		Workers:        workers,
+		WorkerTaskMap:  workerTaskMap,
+		TaskWorkerMap:  taskWorkerMap,
+		MaxRestarts:    maxRestarts,
 	}
 }
 
@@ -68,22 +130,90 @@ func (m *Manager) SelectWorker() string {
 }
 
 func (m *Manager) SendWork() {
+	m.refill()
 	select {
 	case te := <-m.Pending:
-		w := m.SelectWorker()
 		t := te.Task
 
-		logger.Info("sending task to worker", "task_id", t.ID, "worker", w)
+		var w string
+		var values, kv map[string]string
+		if te.State == task.Completed {
+			// Stop events must go to the worker that runs the task.
+			owner, ok := m.GetTaskWorker(t.ID)
+			if !ok {
+				logger.Warn("no worker assigned to task, cannot stop", "task_id", t.ID)
+				return
+			}
+			w = owner
+			m.mu.Lock()
+			m.EventDB[te.ID] = &te
+			m.mu.Unlock()
+		} else {
+			m.mu.Lock()
+			delete(m.queued, t.ID)
+			if stored, ok := m.TaskDB[t.ID]; ok && (stored.State == task.Completed || stored.State == task.Failed) {
+				m.mu.Unlock()
+				logger.Info("task stopped or failed before dispatch, skipping", "task_id", t.ID)
+				return
+			}
+			m.clearDeadlineLocked(t.ID)
+			m.mu.Unlock()
 
-		m.mu.Lock()
-		m.EventDB[te.ID] = &te
-		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w
-		t.State = task.Scheduled
-		m.TaskDB[t.ID] = &t
-		m.mu.Unlock()
+			var err error
+			values, err = m.resolveSecrets(t)
+			if err == nil {
+				kv, err = m.resolveKV(t)
+			}
+			if err != nil {
+				m.mu.Lock()
+				m.failQueuedLocked(t, err.Error())
+				m.mu.Unlock()
+				logger.Error("cannot resolve task inputs, failing task", "task_id", t.ID, "err", err)
+				return
+			}
+
+			// A restarted task goes back to the worker that has its old
+			// container, unless that worker was lost.
+			owner, restart := m.GetTaskWorker(t.ID)
+			if restart {
+				w = owner
+			} else {
+				w = m.SelectWorker()
+			}
+
+			// The task may have been stopped while its inputs were
+			// resolved, so look again before dispatching it.
+			m.mu.Lock()
+			stored, ok := m.TaskDB[t.ID]
+			if !ok || stored.State == task.Completed || stored.State == task.Failed {
+				m.mu.Unlock()
+				logger.Info("task stopped or failed before dispatch, skipping", "task_id", t.ID)
+				return
+			}
+			m.EventDB[te.ID] = &te
+			if !restart {
+				m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
+				m.TaskWorkerMap[t.ID] = w
+			}
+			m.setStateLocked(stored, task.Scheduled, SourceScheduler, "")
+			m.taskEventLocked(stored, EventScheduled, SourceScheduler, "")
+			m.newAllocationLocked(stored, w)
+			if len(stored.Templates) > 0 {
+				m.templateInputs[t.ID] = inputsHash(values, kv)
+			}
+			t = *stored
+			m.mu.Unlock()
+		}
 
-		data, err := json.Marshal(te)
+		logger.Info("sending task to worker", "task_id", t.ID, "worker", w)
+
+		// Secret values go out with this request only; the event kept in
+		// EventDB never holds them.
+		sent := te
+		sent.Task = t
+		sent.Secrets = values
+		sent.KV = kv
+		data, err := json.Marshal(sent)
 		if err != nil {
 			logger.Error("unable to marshal task", "task_id", t.ID, "err", err)
 		}
@@ -95,6 +225,24 @@ func (m *Manager) SendWork() {
 			return
 		}
 		d := json.NewDecoder(resp.Body)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			// The worker is busy; try again on a later pass.
+			if te.State == task.Scheduled {
+				m.mu.Lock()
+				m.setAllocationStateLocked(&t, task.Failed, "worker busy")
+				m.mu.Unlock()
+			}
+			logger.Warn("worker busy, requeueing task", "task_id", t.ID, "worker", w, "status", resp.StatusCode)
+			if err := m.AddTask(te); err != nil {
+				logger.Error("cannot requeue task", "task_id", t.ID, "err", err)
+				if te.State == task.Scheduled {
+					m.mu.Lock()
+					m.failQueuedLocked(t, task.ReasonQueueFull)
+					m.mu.Unlock()
+				}
+			}
+			return
+		}
 		if resp.StatusCode != http.StatusCreated {
 			e := ErrResponse{}
 			err := d.Decode(&e)
@@ -118,14 +266,80 @@ func (m *Manager) SendWork() {
 	}
 }
 
-func (m *Manager) AddTask(te task.TaskEvent) {
-	select {
-	case m.Pending <- te:
-	default:
-		logger.Warn("manager queue full, dropping task", "task_id", te.Task.ID)
+// AddTask queues te for SendWork. A start event for a task that already
+// has one queued is dropped. When neither Pending nor the overflow queue
+// has room, te is rejected with ErrQueueFull.
+func (m *Manager) AddTask(te task.TaskEvent) error {
+	scheduled := te.State == task.Scheduled
+	if scheduled {
+		m.mu.Lock()
+		if m.queued[te.Task.ID] {
+			m.mu.Unlock()
+			logger.Warn("task already queued, dropping duplicate", "task_id", te.Task.ID)
+			return nil
+		}
+		m.queued[te.Task.ID] = true
+		m.mu.Unlock()
+		if te.Task.ScheduleDeadline > 0 {
+			m.setDeadline(te.Task)
+		}
+	}
+	if err := m.enqueue(te); err != nil {
+		if scheduled {
+			m.mu.Lock()
+			delete(m.queued, te.Task.ID)
+			m.clearDeadlineLocked(te.Task.ID)
+			m.mu.Unlock()
+		}
+		m.rejected.Add(1)
+		logger.Warn("manager queue full, rejecting task", "task_id", te.Task.ID, "err", err)
+		return err
+	}
+	return nil
+}
+
+// setDeadline fails t if it is still queued after its ScheduleDeadline.
+func (m *Manager) setDeadline(t task.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clearDeadlineLocked(t.ID)
+	var timer *time.Timer
+	timer = time.AfterFunc(t.ScheduleDeadline, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// The task may have been placed, or queued again with a new
+		// timer, while this one was firing.
+		if m.deadlines[t.ID] == timer {
+			delete(m.deadlines, t.ID)
+			m.failQueuedLocked(t, task.ReasonScheduleDeadlineExceeded)
+			logger.Warn("task not placed within its schedule deadline", "task_id", t.ID)
+		}
+	})
+	m.deadlines[t.ID] = timer
+}
+
+// clearDeadlineLocked stops the schedule deadline of a task that has been
+// placed. The caller must hold m.mu.
+func (m *Manager) clearDeadlineLocked(id uuid.UUID) {
+	if timer, ok := m.deadlines[id]; ok {
+		timer.Stop()
+		delete(m.deadlines, id)
 	}
 }
 
+// failQueuedLocked fails a task that is queued but not yet dispatched. The
+// caller must hold m.mu.
+func (m *Manager) failQueuedLocked(queued task.Task, reason string) {
+	id := queued.ID
+	t, ok := m.TaskDB[id]
+	if !ok || !task.ValidStateTransition(t.State, task.Failed) {
+		return
+	}
+	m.setStateLocked(t, task.Failed, SourceScheduler, reason)
+	t.FinishTime = time.Now().UTC()
+	t.Reason = reason
+}
+
 func (m *Manager) UpdateTasks(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -137,6 +351,7 @@ func (m *Manager) UpdateTasks(ctx context.Context, interval time.Duration) {
 		case <-ticker.C:
 			logger.Info("checking task updates from workers")
 			m.updateTasks()
+			m.refreshTemplates()
 			logger.Info("task updates completed")
 		}
 	}
@@ -149,9 +364,13 @@ func (m *Manager) updateTasks() {
 		resp, err := http.Get(url)
 		if err != nil {
 			logger.Error("error connecting to worker", "worker", worker, "err", err)
+			m.markLost(worker)
 			continue
 		}
 
+		m.mu.Lock()
+		m.setWorkerDownLocked(worker, false)
+		m.mu.Unlock()
 		if resp.StatusCode != http.StatusOK {
 			logger.Error("unexpected status from worker", "worker", worker, "status", resp.StatusCode)
 			continue
@@ -168,18 +387,42 @@ func (m *Manager) updateTasks() {
 			logger.Debug("updating task", "task_id", t.ID)
 
 			m.mu.Lock()
-			_, ok := m.TaskDB[t.ID]
+			stored, ok := m.TaskDB[t.ID]
 			if !ok {
 				logger.Warn("task not found in local db", "task_id", t.ID)
 				m.mu.Unlock()
 				continue
 			}
-			if m.TaskDB[t.ID].State != t.State {
-				m.TaskDB[t.ID].State = t.State
+			m.updateAllocationLocked(t)
+			// A report from before the task was last restarted, or from a
+			// worker it has since moved away from, says nothing more about
+			// it than how that attempt went.
+			if t.RestartCount < stored.RestartCount || t.AllocID != stored.AllocID || m.TaskWorkerMap[t.ID] != worker {
+				stray := t.State == task.Running && m.TaskWorkerMap[t.ID] != worker
+				m.mu.Unlock()
+				if stray {
+					stopStray(worker, t.ID)
+				}
+				continue
+			}
+			// A worker that has not picked up a stop yet still reports
+			// the task Running.
+			stopping := stored.State == task.Stopping && t.State == task.Running
+			if stored.State != t.State && !stopping {
+				if task.CanReach(stored.State, t.State) {
+					m.moveStateLocked(stored, t.State, SourceWorker, t.Reason)
+				} else {
+					logger.Warn("ignoring invalid state from worker", "task_id", t.ID, "from", stored.State, "to", t.State)
+				}
 			}
 			m.TaskDB[t.ID].StartTime = t.StartTime
 			m.TaskDB[t.ID].FinishTime = t.FinishTime
 			m.TaskDB[t.ID].ContainerID = t.ContainerID
+			m.TaskDB[t.ID].HostPorts = t.HostPorts
+			m.TaskDB[t.ID].PortBindings = t.PortBindings
+			m.TaskDB[t.ID].Status = t.Status
+			m.TaskDB[t.ID].Reason = t.Reason
+			m.TaskDB[t.ID].Sidecars = t.Sidecars
 			m.mu.Unlock()
 		}
 	}
@@ -202,6 +445,9 @@ func (m *Manager) checkTaskHealth(t task.Task) error {
 		return fmt.Errorf("no worker assigned to task %s", t.ID)
 	}
 	hostPort := getHostPort(t.HostPorts)
+	if hostPort == nil {
+		return fmt.Errorf("no host port published for task %s", t.ID)
+	}
 	worker := strings.Split(w, ":")
 	url := fmt.Sprintf("http://%s:%s/%s", worker[0], *hostPort, t.HealthCheck)
 
@@ -238,20 +484,66 @@ func (m *Manager) DoHealthChecks(ctx context.Context, interval time.Duration) {
 
 func (m *Manager) doHealthChecks() {
 	for _, t := range m.GetTasks() {
-		if t.State == task.Running && t.RestartCount < m.MaxRestarts {
-			if err := m.checkTaskHealth(*t); err != nil {
-				m.restartTask(t)
+		switch t.State {
+		case task.Running:
+			healthy := true
+			if t.HealthCheck != "" {
+				if err := m.checkTaskHealth(*t); err != nil {
+					healthy = false
+					m.mu.Lock()
+					m.taskEventLocked(t, EventHealthCheckFailed, SourceHealthCheck, err.Error())
+					m.mu.Unlock()
+				}
+			}
+			m.recordHealth(t.ID, healthy)
+			if !healthy && t.RestartCount < m.MaxRestarts {
+				m.restartTask(t, SourceHealthCheck, "health check failed")
+			}
+		case task.Failed:
+			// Batch jobs retry with fresh tasks under their own backoff
+			// limit, and tasks failed before dispatch have nothing to restart.
+			_, dispatched := m.GetTaskWorker(t.ID)
+			if dispatched && t.RestartCount < m.MaxRestarts && !m.isBatchTask(t) {
+				m.restartTask(t, SourceManager, "task failed")
+			}
+		case task.Lost:
+			if t.RestartCount < m.MaxRestarts {
+				m.restartTask(t, SourceManager, task.ReasonWorkerLost)
 			}
-		} else if t.State == task.Failed && t.RestartCount < m.MaxRestarts {
-			m.restartTask(t)
 		}
 	}
 }
 
-func (m *Manager) restartTask(t *task.Task) {
+func (m *Manager) recordHealth(id uuid.UUID, healthy bool) {
 	m.mu.Lock()
+	m.health[id] = healthy
+	m.mu.Unlock()
+}
+
+// lastHealth returns the result of the most recent health check of a task and
+// whether it has been checked at all.
+func (m *Manager) lastHealth(id uuid.UUID) (healthy bool, checked bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	healthy, checked = m.health[id]
+	return healthy, checked
+}
+
+// restartTask marks t Restarting and queues it to start again. Its worker
+// replaces the old container; a Lost task is placed afresh instead.
+func (m *Manager) restartTask(t *task.Task, source EventSource, reason string) {
+	m.mu.Lock()
+	if !task.ValidStateTransition(t.State, task.Restarting) {
+		m.mu.Unlock()
+		return
+	}
+	lost := t.State == task.Lost
 	t.RestartCount++
-	t.State = task.Scheduled
+	m.taskEventLocked(t, EventRestarted, source, fmt.Sprintf("attempt %d: %s", t.RestartCount, reason))
+	m.setStateLocked(t, task.Restarting, source, reason)
+	if lost {
+		m.unassignLocked(t.ID)
+	}
 	m.TaskDB[t.ID] = t
 	m.mu.Unlock()
 
@@ -261,12 +553,70 @@ func (m *Manager) restartTask(t *task.Task) {
 		TimeStamp: time.Now(),
 		Task:      *t,
 	}
-	m.AddTask(te)
+	if err := m.AddTask(te); err != nil {
+		// Leave the task Failed, so the restart is tried again once
+		// there is room.
+		m.mu.Lock()
+		t.RestartCount--
+		m.setStateLocked(t, task.Failed, SourceManager, task.ReasonQueueFull)
+		m.mu.Unlock()
+		return
+	}
 	logger.Info("restarting task", "task_id", t.ID, "attempt", t.RestartCount)
 }
 
+// markLost marks the tasks placed on an unreachable worker Lost, so that
+// they are restarted elsewhere. Tasks being stopped are left alone.
+func (m *Manager) markLost(worker string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setWorkerDownLocked(worker, true)
+	for _, id := range m.WorkerTaskMap[worker] {
+		t, ok := m.TaskDB[id]
+		if !ok || m.TaskWorkerMap[id] != worker || t.State == task.Stopping {
+			continue
+		}
+		if task.ValidStateTransition(t.State, task.Lost) {
+			logger.Warn("worker unreachable, task lost", "task_id", id, "worker", worker)
+			m.setStateLocked(t, task.Lost, SourceManager, task.ReasonWorkerLost)
+			t.Reason = task.ReasonWorkerLost
+			m.setAllocationStateLocked(t, task.Lost, task.ReasonWorkerLost)
+		}
+	}
+}
+
+// unassignLocked forgets which worker a task was placed on. The caller must
+// hold m.mu.
+func (m *Manager) unassignLocked(id uuid.UUID) {
+	w, ok := m.TaskWorkerMap[id]
+	if !ok {
+		return
+	}
+	delete(m.TaskWorkerMap, id)
+	m.WorkerTaskMap[w] = slices.DeleteFunc(m.WorkerTaskMap[w], func(t uuid.UUID) bool { return t == id })
+}
+
+// stopStray stops a task still running on a worker it has been moved away
+// from, such as one that was lost for a while.
+func stopStray(worker string, id uuid.UUID) {
+	logger.Warn("stopping task on a worker it no longer belongs to", "task_id", id, "worker", worker)
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/tasks/%s", worker, id), nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Error("error stopping stray task", "task_id", id, "worker", worker, "err", err)
+		return
+	}
+	resp.Body.Close()
+}
+
 func getHostPort(ports nat.PortMap) *string {
 	for k := range ports {
+		if len(ports[k]) == 0 {
+			continue
+		}
 		return &ports[k][0].HostPort
 	}
 	return nil
@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+func TestScheduleDeadline(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	late := task.Task{ID: uuid.New(), Image: "strm/helloworld-http", ScheduleDeadline: 10 * time.Millisecond}
+	placed := task.Task{ID: uuid.New(), Image: "strm/helloworld-http", ScheduleDeadline: 10 * time.Millisecond}
+	m.submitTask(late)
+	m.submitTask(placed)
+
+	// Placing the second task disarms its deadline. The worker does not
+	// exist, so the task stays Scheduled.
+	m.mu.Lock()
+	m.clearDeadlineLocked(placed.ID)
+	m.TaskDB[placed.ID].State = task.Scheduled
+	m.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+
+	m.mu.RLock()
+	got, placedState := *m.TaskDB[late.ID], m.TaskDB[placed.ID].State
+	m.mu.RUnlock()
+	if got.State != task.Failed || got.Reason != task.ReasonScheduleDeadlineExceeded {
+		t.Fatalf("late task = %v (%q), want Failed with reason %q", got.State, got.Reason, task.ReasonScheduleDeadlineExceeded)
+	}
+	if placedState != task.Scheduled {
+		t.Fatalf("placed task = %v, want Scheduled", placedState)
+	}
+
+	// The failed task's start event is dropped rather than dispatched.
+	m.SendWork()
+	if _, ok := m.GetTaskWorker(late.ID); ok {
+		t.Fatal("task failed by its schedule deadline was dispatched")
+	}
+}
+
+func TestMissingSecretFailsTask(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	tk := task.Task{
+		ID:      uuid.New(),
+		Image:   "strm/helloworld-http",
+		Secrets: []task.SecretRef{{Name: "db-password", Env: "DB_PASSWORD"}},
+	}
+	m.submitTask(tk)
+
+	m.SendWork()
+	if _, ok := m.GetTaskWorker(tk.ID); ok {
+		t.Fatal("task with a missing secret was dispatched")
+	}
+	m.mu.RLock()
+	got := *m.TaskDB[tk.ID]
+	m.mu.RUnlock()
+	if got.State != task.Failed || got.Reason == "" {
+		t.Fatalf("task = %v (%q), want Failed with a reason", got.State, got.Reason)
+	}
+}
+
+// fakeWorker accepts start events and reports whatever tasks it is told to.
+type fakeWorker struct {
+	mu       sync.Mutex
+	started  []task.TaskEvent
+	reported []task.Task
+}
+
+func (f *fakeWorker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		te := task.TaskEvent{}
+		json.NewDecoder(r.Body).Decode(&te)
+		f.started = append(f.started, te)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(te.Task)
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(f.reported)
+	}
+}
+
+func (f *fakeWorker) report(t task.Task) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reported = []task.Task{t}
+}
+
+func TestRestartAndLostTask(t *testing.T) {
+	fw := &fakeWorker{}
+	srv := httptest.NewServer(fw)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	m := New([]string{addr}, 100, 3)
+
+	tk := task.Task{ID: uuid.New(), Image: "strm/helloworld-http"}
+	m.submitTask(tk)
+	m.SendWork()
+
+	state := func() (task.State, int) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.TaskDB[tk.ID].State, m.TaskDB[tk.ID].RestartCount
+	}
+
+	failed := fw.started[0].Task
+	failed.State = task.Failed
+	fw.report(failed)
+	m.updateTasks()
+	m.doHealthChecks()
+	if s, n := state(); s != task.Restarting || n != 1 {
+		t.Fatalf("after failure: %v with %d restarts, want Restarting with 1", s, n)
+	}
+
+	// The worker still reports the failure until it picks up the restart.
+	m.updateTasks()
+	if s, _ := state(); s != task.Restarting {
+		t.Fatalf("stale report moved the task to %v", s)
+	}
+
+	m.SendWork()
+	fw.mu.Lock()
+	n := len(fw.started)
+	last := fw.started[n-1]
+	fw.mu.Unlock()
+	if n != 2 || last.State != task.Scheduled || last.Task.RestartCount != 1 {
+		t.Fatalf("worker got %d start events, last %v with %d restarts", n, last.State, last.Task.RestartCount)
+	}
+
+	running := last.Task
+	running.State = task.Running
+	fw.report(running)
+	m.updateTasks()
+	if s, _ := state(); s != task.Running {
+		t.Fatalf("after restart: %v, want Running", s)
+	}
+
+	srv.Close()
+	m.updateTasks()
+	if s, _ := state(); s != task.Lost {
+		t.Fatalf("with the worker gone: %v, want Lost", s)
+	}
+	m.doHealthChecks()
+	if s, _ := state(); s != task.Restarting {
+		t.Fatalf("lost task: %v, want Restarting", s)
+	}
+	if _, ok := m.GetTaskWorker(tk.ID); ok {
+		t.Fatal("lost task is still assigned to its old worker")
+	}
+
+	allocs, err := m.GetAllocations(tk.ID)
+	if err != nil {
+		t.Fatalf("GetAllocations: unexpected error: %v", err)
+	}
+	if len(allocs) != 2 || allocs[0].State != task.Failed || allocs[1].State != task.Lost {
+		t.Fatalf("allocations = %+v, want a Failed and a Lost attempt", allocs)
+	}
+
+	var types []EventType
+	var since uint64
+	for more := true; more; {
+		page, err := m.GetEvents(tk.ID, since, 2)
+		if err != nil {
+			t.Fatalf("GetEvents: unexpected error: %v", err)
+		}
+		for _, e := range page.Events {
+			types = append(types, e.Type)
+		}
+		since, more = page.Next, page.More
+	}
+	want := []EventType{
+		EventStateChanged, EventScheduled, // placed
+		EventStateChanged,                 // Failed
+		EventRestarted, EventStateChanged, // Restarting
+		EventStateChanged, EventScheduled, // placed again
+		EventStateChanged,                 // Running
+		EventStateChanged,                 // Lost
+		EventRestarted, EventStateChanged, // Restarting
+	}
+	if !slices.Equal(types, want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+}
@@ -0,0 +1,23 @@
+package manager
+
+import (
+	"net/http"
+
+	"github.com/ctfrancia/mongeta/metrics"
+)
+
+// MetricsHandler reports the depth and capacity of the manager's queues and
+// how many task events were rejected or overflowed, in the Prometheus text
+// format.
+func (a *API) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	m := a.Manager
+	overflowDepth, overflowLimit := m.overflow.stats()
+	metrics.Write(w, []metrics.Metric{
+		{Name: "mongeta_manager_queue_depth", Kind: metrics.Gauge, Help: "Task events waiting in the pending queue.", Value: uint64(len(m.Pending))},
+		{Name: "mongeta_manager_queue_capacity", Kind: metrics.Gauge, Help: "Size of the pending queue.", Value: uint64(cap(m.Pending))},
+		{Name: "mongeta_manager_overflow_depth", Kind: metrics.Gauge, Help: "Task events waiting in the overflow queue.", Value: uint64(overflowDepth)},
+		{Name: "mongeta_manager_overflow_capacity", Kind: metrics.Gauge, Help: "Size of the overflow queue.", Value: uint64(overflowLimit)},
+		{Name: "mongeta_manager_queue_rejected_total", Kind: metrics.Counter, Help: "Task events rejected because the queues were full.", Value: m.rejected.Load()},
+		{Name: "mongeta_manager_queue_overflowed_total", Kind: metrics.Counter, Help: "Task events put in the overflow queue.", Value: m.overflowed.Load()},
+	})
+}
@@ -0,0 +1,180 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+)
+
+var ErrQueueFull = errors.New("queue full")
+
+// DefaultRetryAfter is how long clients are told to wait before retrying a
+// rejected submission, unless Manager.RetryAfter says otherwise.
+const DefaultRetryAfter = 10 * time.Second
+
+// overflowQueue holds the task events that did not fit in Manager.Pending,
+// oldest first. With a file, every change is written through to it, so the
+// events survive a restart of the manager. Secret values are resolved at
+// dispatch and never reach the file.
+type overflowQueue struct {
+	mu     sync.Mutex
+	events []task.TaskEvent
+	limit  int
+	path   string
+}
+
+// readOverflow returns the events kept in the file at path, if it exists.
+func readOverflow(path string) ([]task.TaskEvent, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var events []task.TaskEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("cannot read overflow queue %s: %w", path, err)
+	}
+	return events, nil
+}
+
+// pushLocked appends te unless the queue is at its limit. force skips the
+// limit, for events that must not be lost. The caller must hold q.mu.
+func (q *overflowQueue) pushLocked(te task.TaskEvent, force bool) error {
+	if !force && len(q.events) >= q.limit {
+		return ErrQueueFull
+	}
+	q.events = append(q.events, te)
+	if err := q.saveLocked(); err != nil {
+		q.events = q.events[:len(q.events)-1]
+		return err
+	}
+	return nil
+}
+
+// stats returns the number of events waiting and the limit.
+func (q *overflowQueue) stats() (depth, limit int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events), q.limit
+}
+
+// saveLocked replaces the file with the current events. The caller must
+// hold q.mu.
+func (q *overflowQueue) saveLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(q.events)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("cannot write overflow queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write overflow queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write overflow queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("cannot write overflow queue: %w", err)
+	}
+	return nil
+}
+
+// SetOverflow lets up to limit task events wait beyond the Pending queue
+// instead of being rejected, kept in the file at path if it is set. Events
+// left in the file by a previous run are queued again.
+func (m *Manager) SetOverflow(limit int, path string) error {
+	var events []task.TaskEvent
+	if path != "" {
+		var err error
+		if events, err = readOverflow(path); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	for _, te := range events {
+		if te.State == task.Scheduled {
+			m.queued[te.Task.ID] = true
+		}
+	}
+	m.mu.Unlock()
+
+	q := m.overflow
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(events, q.events...)
+	q.limit = limit
+	q.path = path
+	return q.saveLocked()
+}
+
+// enqueue puts te in Pending, or behind the events already waiting in the
+// overflow queue. Stop events go to Pending whenever it has room and are
+// never rejected, so a task can always be stopped. Holding the overflow
+// queue's lock throughout keeps start events in order.
+func (m *Manager) enqueue(te task.TaskEvent) error {
+	q := m.overflow
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// Whatever waits in the overflow queue goes first, so te is only
+	// turned away if Pending is really full.
+	m.refillLocked()
+	stop := te.State == task.Completed
+	if stop || len(q.events) == 0 {
+		select {
+		case m.Pending <- te:
+			return nil
+		default:
+		}
+	}
+	if err := q.pushLocked(te, stop); err != nil {
+		return err
+	}
+	m.overflowed.Add(1)
+	return nil
+}
+
+// refill moves waiting overflow events into Pending while there is room.
+func (m *Manager) refill() {
+	m.overflow.mu.Lock()
+	defer m.overflow.mu.Unlock()
+	m.refillLocked()
+}
+
+// refillLocked is refill for a caller that holds the overflow queue's lock.
+func (m *Manager) refillLocked() {
+	q := m.overflow
+	moved := 0
+fill:
+	for moved < len(q.events) {
+		select {
+		case m.Pending <- q.events[moved]:
+			moved++
+		default:
+			break fill
+		}
+	}
+	if moved == 0 {
+		return
+	}
+	q.events = q.events[moved:]
+	if err := q.saveLocked(); err != nil {
+		logger.Error("cannot update overflow queue", "err", err)
+	}
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+func TestQueueBackpressure(t *testing.T) {
+	m := New([]string{"w1:8080"}, 1, 3)
+	path := filepath.Join(t.TempDir(), "overflow.json")
+	if err := m.SetOverflow(1, path); err != nil {
+		t.Fatal(err)
+	}
+	a := &API{Manager: m}
+	a.initRouter()
+	srv := httptest.NewServer(a.Router)
+	defer srv.Close()
+
+	post := func() *http.Response {
+		body := `{"ID":"` + uuid.NewString() + `","State":"Scheduled","Task":{"ID":"` + uuid.NewString() + `","Image":"nginx"}}`
+		resp, err := http.Post(srv.URL+"/tasks", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST /tasks: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// One task fills Pending and the next waits in the overflow queue.
+	for i := range 2 {
+		if resp := post(); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("submission %d: status %d", i, resp.StatusCode)
+		}
+	}
+	resp := post()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "10" {
+		t.Fatalf("full queues: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if n := len(m.GetTasks()); n != 2 {
+		t.Fatalf("%d tasks recorded, want 2", n)
+	}
+
+	// The overflow queue outlives the manager.
+	events, err := readOverflow(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("%d events in overflow file, want 1", len(events))
+	}
+
+	<-m.Pending
+	m.refill()
+	if depth, _ := m.overflow.stats(); len(m.Pending) != 1 || depth != 0 {
+		t.Fatalf("after refill: %d pending, %d overflowed", len(m.Pending), depth)
+	}
+
+	rec := httptest.NewRecorder()
+	a.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{"mongeta_manager_queue_rejected_total 1", "mongeta_manager_queue_overflowed_total 1"} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, rec.Body)
+		}
+	}
+}
+
+func TestStopEventsWithoutOverflow(t *testing.T) {
+	m := New([]string{"w1:8080"}, 1, 3)
+	start := func() task.TaskEvent {
+		return task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: task.Task{ID: uuid.New()}}
+	}
+	stop := task.TaskEvent{ID: uuid.New(), State: task.Completed, Task: task.Task{ID: uuid.New()}}
+
+	if err := m.AddTask(start()); err != nil {
+		t.Fatalf("first start: %v", err)
+	}
+	// Pending is full, so the stop waits in the overflow queue even though
+	// it has no room for anything else.
+	if err := m.AddTask(stop); err != nil {
+		t.Fatalf("stop with Pending full: %v", err)
+	}
+	if err := m.AddTask(start()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("start with both queues full: err = %v, want ErrQueueFull", err)
+	}
+
+	// Once Pending has room the stop moves up, and a start after it
+	// waits for room rather than being rejected by the stop ahead of it.
+	<-m.Pending
+	if err := m.AddTask(start()); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("start behind the stop: err = %v, want ErrQueueFull", err)
+	}
+	if te := <-m.Pending; te.ID != stop.ID {
+		t.Fatalf("dequeued %v, want the stop event", te.State)
+	}
+	if err := m.AddTask(start()); err != nil {
+		t.Fatalf("start with room in Pending: %v", err)
+	}
+
+	// A stop goes straight to Pending while it has room.
+	<-m.Pending
+	if err := m.AddTask(stop); err != nil || len(m.Pending) != 1 {
+		t.Fatalf("stop with room in Pending: err %v, %d pending", err, len(m.Pending))
+	}
+}
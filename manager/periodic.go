@@ -0,0 +1,264 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// OverlapPolicy decides what happens when a periodic job is due while an
+// earlier run is still going.
+type OverlapPolicy string
+
+const (
+	// OverlapAllow starts the new run alongside the old one.
+	OverlapAllow OverlapPolicy = "allow"
+	// OverlapForbid holds the new run back until the old one has finished
+	// or the starting deadline has passed.
+	OverlapForbid OverlapPolicy = "forbid"
+	// OverlapReplace stops the old run and starts the new one.
+	OverlapReplace OverlapPolicy = "replace"
+)
+
+const (
+	defaultSuccessfulHistoryLimit = 3
+	defaultFailedHistoryLimit     = 1
+)
+
+// PeriodicConfig turns a batch job into a template that is launched as a new
+// child batch job every time Schedule fires. Schedule is a standard five
+// field cron expression or a descriptor such as @hourly, evaluated in
+// TimeZone (UTC when empty). A run that cannot start within
+// StartingDeadline of its scheduled time is skipped; zero means no deadline.
+type PeriodicConfig struct {
+	Schedule               string
+	TimeZone               string
+	OverlapPolicy          OverlapPolicy
+	StartingDeadline       time.Duration
+	SuccessfulHistoryLimit int
+	FailedHistoryLimit     int
+}
+
+func (p *PeriodicConfig) withDefaults() {
+	if p.OverlapPolicy == "" {
+		p.OverlapPolicy = OverlapAllow
+	}
+	if p.SuccessfulHistoryLimit <= 0 {
+		p.SuccessfulHistoryLimit = defaultSuccessfulHistoryLimit
+	}
+	if p.FailedHistoryLimit <= 0 {
+		p.FailedHistoryLimit = defaultFailedHistoryLimit
+	}
+}
+
+func validatePeriodic(j *Job) error {
+	p := j.Periodic
+	if p == nil {
+		return nil
+	}
+	if j.Type != JobTypeBatch {
+		return fmt.Errorf("%w: only batch jobs can be periodic", ErrInvalidJobSpec)
+	}
+	if _, err := cron.ParseStandard(p.Schedule); err != nil {
+		return fmt.Errorf("%w: schedule %q: %v", ErrInvalidJobSpec, p.Schedule, err)
+	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		return fmt.Errorf("%w: time zone %q: %v", ErrInvalidJobSpec, p.TimeZone, err)
+	}
+	switch p.OverlapPolicy {
+	case "", OverlapAllow, OverlapForbid, OverlapReplace:
+	default:
+		return fmt.Errorf("%w: unknown overlap policy %q", ErrInvalidJobSpec, p.OverlapPolicy)
+	}
+	if p.StartingDeadline < 0 {
+		return fmt.Errorf("%w: starting deadline must not be negative", ErrInvalidJobSpec)
+	}
+	return nil
+}
+
+// nextRun returns the first time the schedule fires after t.
+func (p *PeriodicConfig) nextRun(t time.Time) (time.Time, error) {
+	sched, err := cron.ParseStandard(p.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(t.In(loc)), nil
+}
+
+// lastDue returns the most recent scheduled time in (after, now], if any.
+func (p *PeriodicConfig) lastDue(after, now time.Time) (time.Time, bool, error) {
+	var due time.Time
+	for {
+		next, err := p.nextRun(after)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if next.IsZero() || next.After(now) {
+			return due, !due.IsZero(), nil
+		}
+		due, after = next, next
+	}
+}
+
+func (m *Manager) RunPeriodicJobs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.launchPeriodicJobs(time.Now())
+		}
+	}
+}
+
+func (m *Manager) launchPeriodicJobs(now time.Time) {
+	for _, j := range m.GetJobs(JobTypeBatch) {
+		if j.Periodic == nil {
+			continue
+		}
+		m.launchPeriodicJob(j, now)
+		m.pruneRuns(j)
+	}
+}
+
+func (m *Manager) launchPeriodicJob(parent *Job, now time.Time) {
+	p := parent.Periodic
+	after := parent.Status.LastScheduleTime
+	if after.IsZero() {
+		after = parent.CreateTime
+	}
+
+	due, ok, err := p.lastDue(after, now)
+	if err != nil {
+		logger.Error("invalid periodic schedule", "job_id", parent.ID, "err", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if p.StartingDeadline > 0 && now.Sub(due) > p.StartingDeadline {
+		logger.Warn("missed periodic run past its starting deadline", "job_id", parent.ID, "scheduled", due)
+		m.setLastScheduleTime(parent.ID, due)
+		return
+	}
+
+	active := m.activeRuns(parent.ID)
+	if len(active) > 0 {
+		switch p.OverlapPolicy {
+		case OverlapForbid:
+			logger.Info("previous periodic run still active, holding back", "job_id", parent.ID, "scheduled", due)
+			return
+		case OverlapReplace:
+			for _, run := range active {
+				logger.Info("replacing active periodic run", "job_id", parent.ID, "run_id", run.ID)
+				m.DeleteJob(run.ID)
+			}
+		}
+	}
+
+	if _, err := m.launchRun(parent, due); err != nil {
+		logger.Error("error launching periodic run", "job_id", parent.ID, "err", err)
+		return
+	}
+	m.setLastScheduleTime(parent.ID, due)
+}
+
+// TriggerJob launches a run of a periodic job immediately, outside its
+// schedule and regardless of its overlap policy.
+func (m *Manager) TriggerJob(id uuid.UUID) (*Job, error) {
+	parent, ok := m.GetJob(id)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if parent.Periodic == nil {
+		return nil, fmt.Errorf("%w: job is not periodic", ErrInvalidJobSpec)
+	}
+	return m.launchRun(parent, time.Now())
+}
+
+// launchRun creates a child batch job of parent for the run scheduled at t.
+func (m *Manager) launchRun(parent *Job, t time.Time) (*Job, error) {
+	run := *parent
+	run.Name = fmt.Sprintf("%s-%d", parent.Name, t.Unix())
+	run.Periodic = nil
+	run.ParentID = parent.ID
+
+	created, err := m.AddJob(run)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("launched periodic run", "job_id", parent.ID, "run_id", created.ID, "scheduled", t)
+	return created, nil
+}
+
+func (m *Manager) setLastScheduleTime(id uuid.UUID, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.JobDB[id]; ok {
+		j.Status.LastScheduleTime = t
+	}
+}
+
+// runs returns copies of the child jobs launched by a periodic job, oldest
+// first.
+func (m *Manager) runs(parentID uuid.UUID) []*Job {
+	m.mu.RLock()
+	var runs []*Job
+	for _, j := range m.JobDB {
+		if j.ParentID == parentID {
+			c := *j
+			runs = append(runs, &c)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(runs, func(a, b *Job) int {
+		return a.CreateTime.Compare(b.CreateTime)
+	})
+	return runs
+}
+
+func (m *Manager) activeRuns(parentID uuid.UUID) []*Job {
+	var active []*Job
+	for _, run := range m.runs(parentID) {
+		if run.Status.State == JobRunning {
+			active = append(active, run)
+		}
+	}
+	return active
+}
+
+// pruneRuns deletes finished runs beyond the parent's history limits,
+// oldest first.
+func (m *Manager) pruneRuns(parent *Job) {
+	var succeeded, failed []*Job
+	for _, run := range m.runs(parent.ID) {
+		switch run.Status.State {
+		case JobSucceeded:
+			succeeded = append(succeeded, run)
+		case JobFailed:
+			failed = append(failed, run)
+		}
+	}
+
+	prune := func(runs []*Job, limit int) {
+		for _, run := range runs[:max(len(runs)-limit, 0)] {
+			logger.Debug("pruning periodic run", "job_id", parent.ID, "run_id", run.ID)
+			m.DeleteJob(run.ID)
+		}
+	}
+	prune(succeeded, parent.Periodic.SuccessfulHistoryLimit)
+	prune(failed, parent.Periodic.FailedHistoryLimit)
+}
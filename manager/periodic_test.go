@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ctfrancia/mongeta/task"
+)
+
+func newTestPeriodicJob(t *testing.T, m *Manager, p PeriodicConfig, created time.Time) *Job {
+	t.Helper()
+	j, err := m.AddJob(Job{
+		Name:     "nightly",
+		Type:     JobTypeBatch,
+		Template: task.Task{Image: "busybox"},
+		Periodic: &p,
+	})
+	if err != nil {
+		t.Fatalf("AddJob: unexpected error: %v", err)
+	}
+	m.mu.Lock()
+	m.JobDB[j.ID].CreateTime = created
+	m.mu.Unlock()
+	j, _ = m.GetJob(j.ID)
+	return j
+}
+
+func TestPeriodicLastDue(t *testing.T) {
+	p := PeriodicConfig{Schedule: "0 * * * *"}
+	after := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC)
+
+	due, ok, err := p.lastDue(after, now)
+	if err != nil || !ok {
+		t.Fatalf("lastDue: ok=%v err=%v", ok, err)
+	}
+	if want := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC); !due.Equal(want) {
+		t.Errorf("lastDue = %v, want %v", due, want)
+	}
+
+	if _, ok, _ := p.lastDue(now, now.Add(time.Minute)); ok {
+		t.Error("lastDue reported a run that is not due yet")
+	}
+}
+
+func TestPeriodicTimeZone(t *testing.T) {
+	p := PeriodicConfig{Schedule: "0 2 * * *", TimeZone: "America/New_York"}
+	next, err := p.nextRun(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("nextRun: unexpected error: %v", err)
+	}
+	if want := time.Date(2026, 1, 1, 7, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("nextRun = %v, want %v", next.UTC(), want)
+	}
+}
+
+func TestPeriodicValidation(t *testing.T) {
+	m := New([]string{"w1:8080"}, 10, 3)
+	tests := []Job{
+		{Name: "a", Type: JobTypeBatch, Template: task.Task{Image: "busybox"}, Periodic: &PeriodicConfig{Schedule: "not cron"}},
+		{Name: "b", Type: JobTypeBatch, Template: task.Task{Image: "busybox"}, Periodic: &PeriodicConfig{Schedule: "@hourly", TimeZone: "Nowhere/Special"}},
+		{Name: "c", Type: JobTypeBatch, Template: task.Task{Image: "busybox"}, Periodic: &PeriodicConfig{Schedule: "@hourly", OverlapPolicy: "sometimes"}},
+		{Name: "d", Type: JobTypeService, Template: task.Task{Image: "busybox"}, Periodic: &PeriodicConfig{Schedule: "@hourly"}},
+	}
+	for _, j := range tests {
+		if _, err := m.AddJob(j); !errors.Is(err, ErrInvalidJobSpec) {
+			t.Errorf("AddJob(%s) error = %v, want ErrInvalidJobSpec", j.Name, err)
+		}
+	}
+}
+
+func TestPeriodicOverlapPolicies(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC)
+	created := now.Add(-time.Hour)
+
+	tests := []struct {
+		policy     OverlapPolicy
+		wantRuns   int
+		wantActive int
+	}{
+		{OverlapAllow, 2, 2},
+		{OverlapForbid, 1, 1},
+		{OverlapReplace, 1, 1},
+	}
+	for _, tt := range tests {
+		m := New([]string{"w1:8080"}, 100, 3)
+		j := newTestPeriodicJob(t, m, PeriodicConfig{Schedule: "*/10 * * * *", OverlapPolicy: tt.policy}, created)
+
+		m.launchPeriodicJobs(now)
+		first := m.runs(j.ID)
+		m.launchPeriodicJobs(now.Add(10 * time.Minute))
+
+		runs := m.runs(j.ID)
+		if len(runs) != tt.wantRuns {
+			t.Errorf("%s: runs = %d, want %d", tt.policy, len(runs), tt.wantRuns)
+		}
+		if got := len(m.activeRuns(j.ID)); got != tt.wantActive {
+			t.Errorf("%s: active runs = %d, want %d", tt.policy, got, tt.wantActive)
+		}
+		if tt.policy == OverlapReplace && len(runs) == 1 && runs[0].ID == first[0].ID {
+			t.Errorf("%s: the first run was not replaced", tt.policy)
+		}
+	}
+}
+
+func TestPeriodicStartingDeadline(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	now := time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC)
+	j := newTestPeriodicJob(t, m, PeriodicConfig{Schedule: "0 * * * *", StartingDeadline: time.Minute}, now.Add(-2*time.Hour))
+
+	m.launchPeriodicJobs(now)
+	if got := len(m.runs(j.ID)); got != 0 {
+		t.Errorf("runs = %d, want the late run skipped", got)
+	}
+	got, _ := m.GetJob(j.ID)
+	if want := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC); !got.Status.LastScheduleTime.Equal(want) {
+		t.Errorf("LastScheduleTime = %v, want %v", got.Status.LastScheduleTime, want)
+	}
+}
+
+func TestPeriodicHistoryLimit(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestPeriodicJob(t, m, PeriodicConfig{Schedule: "@daily", SuccessfulHistoryLimit: 2}, time.Now())
+
+	for range 4 {
+		run, err := m.TriggerJob(j.ID)
+		if err != nil {
+			t.Fatalf("TriggerJob: unexpected error: %v", err)
+		}
+		m.finishJob(run.ID, JobSucceeded, "")
+	}
+	m.pruneRuns(j)
+
+	if got := len(m.runs(j.ID)); got != 2 {
+		t.Errorf("runs after pruning = %d, want 2", got)
+	}
+}
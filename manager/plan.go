@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"errors"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// PlanAction is what applying a plan would do to one task.
+type PlanAction string
+
+const (
+	// PlanCreate places a new task.
+	PlanCreate PlanAction = "create"
+	// PlanUpdate moves a running task to the new version without
+	// restarting it.
+	PlanUpdate PlanAction = "update"
+	// PlanDestroy stops a task without replacing it.
+	PlanDestroy PlanAction = "destroy"
+	// PlanReplace stops a task and places one of the new version in its
+	// stead, following the job's update strategy.
+	PlanReplace PlanAction = "replace"
+)
+
+// TaskPlan is the planned fate of one task. TaskID is nil for tasks that
+// would be created.
+type TaskPlan struct {
+	Action PlanAction
+	TaskID uuid.UUID
+	Name   string
+}
+
+// JobPlan is a dry run of a job update. Applying the same spec with
+// CheckIndex fails if the job has changed since the plan was made.
+type JobPlan struct {
+	JobID      uuid.UUID
+	CheckIndex uint64
+	Version    int
+	Diff       []FieldDiff
+	Deployment bool
+	Tasks      []TaskPlan
+	Summary    map[PlanAction]int
+}
+
+var ErrCheckIndexMismatch = errors.New("job changed since plan")
+
+// touchLocked gives j a new ModifyIndex. The caller must hold m.mu.
+func (m *Manager) touchLocked(j *Job) {
+	m.index++
+	j.ModifyIndex = m.index
+}
+
+// PlanJob reports what UpdateJob would do with spec without changing
+// anything. For a service, tasks are matched to the new replica count in the
+// order the reconciler keeps them, so those listed as destroyed are the ones
+// a scale-down would stop. A batch or periodic job only gets a diff and the
+// new version, as its placed tasks are left to finish.
+func (m *Manager) PlanJob(id uuid.UUID, spec Job) (*JobPlan, error) {
+	j, ok := m.GetJob(id)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if err := validateJobUpdate(j, &spec); err != nil {
+		return nil, err
+	}
+
+	p := &JobPlan{
+		JobID:      id,
+		CheckIndex: j.ModifyIndex,
+		Version:    j.Version,
+		Diff:       DiffJobs(*j, spec),
+		Summary:    make(map[PlanAction]int),
+	}
+	if len(p.Diff) == 0 {
+		return p, nil
+	}
+
+	m.mu.RLock()
+	p.Version = m.nextVersionLocked(j)
+	m.mu.RUnlock()
+	if j.Type == JobTypeBatch {
+		return p, nil
+	}
+	p.Deployment = templateChanged(j.Template, spec.Template)
+
+	keep := PlanUpdate
+	if p.Deployment {
+		keep = PlanReplace
+	}
+	live := m.liveTasks(id)
+	for i, t := range live {
+		action := keep
+		if i >= spec.Replicas {
+			action = PlanDestroy
+		} else if !p.Deployment && t.JobVersion != j.Version {
+			// A task left over from an unfinished deployment is still
+			// replaced by it.
+			action = PlanReplace
+		}
+		p.add(action, t)
+	}
+	for range spec.Replicas - len(live) {
+		p.add(PlanCreate, nil)
+	}
+	return p, nil
+}
+
+func (p *JobPlan) add(action PlanAction, t *task.Task) {
+	tp := TaskPlan{Action: action}
+	if t != nil {
+		tp.TaskID = t.ID
+		tp.Name = t.Name
+	}
+	p.Tasks = append(p.Tasks, tp)
+	p.Summary[action]++
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPlanAndApplyJob(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 3)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	spec := *j
+	spec.Replicas = 2
+	p, err := m.PlanJob(j.ID, spec)
+	if err != nil {
+		t.Fatalf("PlanJob: unexpected error: %v", err)
+	}
+	if p.Deployment || p.Summary[PlanUpdate] != 2 || p.Summary[PlanDestroy] != 1 {
+		t.Fatalf("scale-down plan = %+v, want 2 updated and 1 destroyed in place", p)
+	}
+
+	spec.Replicas = 4
+	spec.Template.Image = "strm/helloworld-http:v2"
+	p, err = m.PlanJob(j.ID, spec)
+	if err != nil {
+		t.Fatalf("PlanJob: unexpected error: %v", err)
+	}
+	if !p.Deployment || p.Summary[PlanReplace] != 3 || p.Summary[PlanCreate] != 1 {
+		t.Fatalf("template plan = %+v, want 3 replaced and 1 created by a deployment", p)
+	}
+	if got, _ := m.GetJob(j.ID); got.Version != j.Version {
+		t.Fatalf("planning changed the job to version %d", got.Version)
+	}
+
+	// Someone else scales the job after the plan was made.
+	if _, err := m.ScaleJob(j.ID, 5); err != nil {
+		t.Fatalf("ScaleJob: unexpected error: %v", err)
+	}
+	if _, err := m.ApplyJob(j.ID, spec, p.CheckIndex); !errors.Is(err, ErrCheckIndexMismatch) {
+		t.Fatalf("ApplyJob with stale index: err = %v, want ErrCheckIndexMismatch", err)
+	}
+
+	p, _ = m.PlanJob(j.ID, spec)
+	applied, err := m.ApplyJob(j.ID, spec, p.CheckIndex)
+	if err != nil {
+		t.Fatalf("ApplyJob: unexpected error: %v", err)
+	}
+	if applied.Version != p.Version || applied.Replicas != 4 {
+		t.Fatalf("applied job = version %d with %d replicas, want version %d with 4", applied.Version, applied.Replicas, p.Version)
+	}
+}
+
+func TestPlanBatchJob(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestBatchJob(t, m, 3, 1, 0)
+	m.reconcileJobs()
+
+	spec := *j
+	spec.Parallelism = 2
+	spec.Template.Image = "busybox:1.36"
+	p, err := m.PlanJob(j.ID, spec)
+	if err != nil {
+		t.Fatalf("PlanJob: unexpected error: %v", err)
+	}
+	if p.Version != j.Version+1 || p.Deployment || len(p.Diff) != 2 || len(p.Summary) != 0 {
+		t.Fatalf("batch plan = %+v, want the next version with two changed fields and no task changes", p)
+	}
+	applied, err := m.ApplyJob(j.ID, spec, p.CheckIndex)
+	if err != nil {
+		t.Fatalf("ApplyJob: unexpected error: %v", err)
+	}
+	if applied.Version != p.Version || applied.Parallelism != 2 {
+		t.Fatalf("applied job = version %d with parallelism %d, want version %d with 2", applied.Version, applied.Parallelism, p.Version)
+	}
+}
@@ -0,0 +1,69 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ctfrancia/mongeta/secrets"
+	"github.com/go-chi/chi/v5"
+)
+
+type secretRequest struct {
+	Name  string
+	Value string
+}
+
+func writeSecretError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, secrets.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, secrets.ErrInvalidName):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// CreateSecretHandler stores a secret, replacing any earlier value of the
+// same name. The response carries its metadata only.
+func (a *API) CreateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := secretRequest{}
+	if err := d.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling secret: %v", err))
+		return
+	}
+
+	created, err := a.Manager.Secrets.Put(req.Name, []byte(req.Value))
+	if err != nil {
+		writeSecretError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	for _, meta := range a.Manager.Secrets.List() {
+		if meta.Name == req.Name {
+			writeJSON(w, status, meta)
+			return
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func (a *API) GetSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.Secrets.List())
+}
+
+func (a *API) DeleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Manager.Secrets.Delete(chi.URLParam(r, "name")); err != nil {
+		writeSecretError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"fmt"
+
+	"github.com/ctfrancia/mongeta/task"
+)
+
+// resolveSecrets looks up the values of every secret t and its templates
+// reference, for the events sent to its worker.
+func (m *Manager) resolveSecrets(t task.Task) (map[string]string, error) {
+	names, _, err := task.TemplateRefs(t)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range t.Secrets {
+		names = append(names, ref.Name)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		v, err := m.Secrets.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve secret: %w", err)
+		}
+		values[name] = string(v)
+	}
+	return values, nil
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/ctfrancia/mongeta/jobspec"
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// isYAML reports whether the request body is a YAML job spec rather than
+// JSON.
+func isYAML(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch mt {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// SubmitTask assigns a new ID to t, records it as Pending and queues it for
+// scheduling. Any ID or state set by the client is ignored. If it cannot be
+// queued, t is forgotten again and the error returned.
+func (m *Manager) SubmitTask(t task.Task) (task.Task, error) {
+	t.ID = uuid.New()
+	t.State = task.Pending
+	t.ContainerID = ""
+	t.AllocID = uuid.Nil
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.RestartCount = 0
+	m.mu.Lock()
+	m.TaskDB[t.ID] = &t
+	m.mu.Unlock()
+	err := m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		TimeStamp: time.Now(),
+		Task:      t,
+	})
+	if err != nil {
+		m.mu.Lock()
+		delete(m.TaskDB, t.ID)
+		m.mu.Unlock()
+		return task.Task{}, err
+	}
+	logger.Info("submitted task", "task_id", t.ID, "name", t.Name)
+	return t, nil
+}
+
+// jobFromSpec converts a parsed service or batch spec into a Job.
+func jobFromSpec(s *jobspec.Spec) (Job, error) {
+	j := Job{
+		Name:         s.Name,
+		Template:     s.ToTask(),
+		Completions:  s.Completions,
+		Parallelism:  s.Parallelism,
+		BackoffLimit: s.BackoffLimit,
+	}
+
+	switch s.Type {
+	case jobspec.TypeService:
+		j.Type = JobTypeService
+		j.Replicas = *s.Replicas
+	case jobspec.TypeBatch:
+		j.Type = JobTypeBatch
+	default:
+		return Job{}, fmt.Errorf("%w: spec of type %q is not a job; submit it to /tasks", ErrInvalidJobSpec, s.Type)
+	}
+
+	if u := s.Update; u != nil {
+		j.Update = UpdateStrategy{
+			Type:            UpdateType(u.Type),
+			Canary:          u.Canary,
+			MaxParallel:     u.MaxParallel,
+			MaxSurge:        u.MaxSurge,
+			MinHealthyTime:  time.Duration(u.MinHealthyTime),
+			HealthyDeadline: time.Duration(u.HealthyDeadline),
+			AutoRevert:      u.AutoRevert,
+		}
+	}
+	if p := s.Periodic; p != nil {
+		j.Periodic = &PeriodicConfig{
+			Schedule:               p.Schedule,
+			TimeZone:               p.TimeZone,
+			OverlapPolicy:          OverlapPolicy(p.OverlapPolicy),
+			StartingDeadline:       time.Duration(p.StartingDeadline),
+			SuccessfulHistoryLimit: p.SuccessfulHistoryLimit,
+			FailedHistoryLimit:     p.FailedHistoryLimit,
+		}
+	}
+	return j, nil
+}
@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// resolveKV looks up the keys the templates of t read. Missing keys are left
+// out: a template may fall back with keyOrDefault, and otherwise fails to
+// render on the worker.
+func (m *Manager) resolveKV(t task.Task) (map[string]string, error) {
+	_, keys, err := task.TemplateRefs(t)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	kv := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := m.KV[k]; ok {
+			kv[k] = v
+		}
+	}
+	return kv, nil
+}
+
+// inputsHash identifies a set of template inputs without keeping the
+// secret values themselves around.
+func inputsHash(secrets, kv map[string]string) string {
+	h := sha256.New()
+	for _, m := range []map[string]string{secrets, kv} {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			fmt.Fprintf(h, "%q=%q\n", k, m[k])
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// refreshTemplates sends new inputs to the workers of running tasks whose
+// templates read secrets or keys that have changed since they were sent.
+func (m *Manager) refreshTemplates() {
+	type running struct {
+		t      task.Task
+		worker string
+	}
+	var tasks []running
+	m.mu.Lock()
+	for id := range m.templateInputs {
+		if t, ok := m.TaskDB[id]; !ok || t.State != task.Running {
+			delete(m.templateInputs, id)
+		}
+	}
+	for id, t := range m.TaskDB {
+		if t.State == task.Running && len(t.Templates) > 0 {
+			if w, ok := m.TaskWorkerMap[id]; ok {
+				tasks = append(tasks, running{*t, w})
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, r := range tasks {
+		secrets, err := m.resolveSecrets(r.t)
+		if err != nil {
+			// Keep the file as it is rather than break the task.
+			logger.Warn("cannot resolve template inputs", "task_id", r.t.ID, "err", err)
+			continue
+		}
+		kv, err := m.resolveKV(r.t)
+		if err != nil {
+			continue
+		}
+		hash := inputsHash(secrets, kv)
+		m.mu.RLock()
+		sent, ok := m.templateInputs[r.t.ID]
+		m.mu.RUnlock()
+		if ok && sent == hash {
+			continue
+		}
+
+		logger.Info("template inputs changed, sending to worker", "task_id", r.t.ID, "worker", r.worker)
+		if err := sendTemplateInputs(r.worker, r.t, secrets, kv); err != nil {
+			logger.Error("error sending template inputs", "task_id", r.t.ID, "worker", r.worker, "err", err)
+			continue
+		}
+		m.mu.Lock()
+		m.templateInputs[r.t.ID] = hash
+		m.mu.Unlock()
+	}
+}
+
+func sendTemplateInputs(w string, t task.Task, secrets, kv map[string]string) error {
+	data, err := json.Marshal(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		TimeStamp: time.Now().UTC(),
+		Task:      t,
+		Secrets:   secrets,
+		KV:        kv,
+	})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://%s/tasks/%s/templates", w, t.ID)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("worker returned %s", resp.Status)
+	}
+	return nil
+}
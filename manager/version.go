@@ -0,0 +1,287 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// JobVersion is an immutable snapshot of a job's spec as it was submitted.
+type JobVersion struct {
+	Version    int
+	SubmitTime time.Time
+	Spec       Job
+}
+
+// DiffType says how a field changed between two versions.
+type DiffType string
+
+const (
+	DiffAdded   DiffType = "added"
+	DiffRemoved DiffType = "removed"
+	DiffEdited  DiffType = "edited"
+)
+
+// FieldDiff is a change to one field of a job spec. Field is a dotted path
+// such as "Template.Image" or "Template.ExposedPorts[80/tcp]".
+type FieldDiff struct {
+	Field string
+	Type  DiffType
+	Old   string
+	New   string
+}
+
+var ErrVersionNotFound = errors.New("job version not found")
+
+// recordVersionLocked appends a snapshot of j to its history. The caller
+// must hold m.mu.
+func (m *Manager) recordVersionLocked(j *Job) {
+	m.VersionDB[j.ID] = append(m.VersionDB[j.ID], JobVersion{
+		Version:    j.Version,
+		SubmitTime: time.Now().UTC(),
+		Spec:       specOf(*j),
+	})
+}
+
+// nextVersionLocked returns the version number the next change to j gets.
+// Numbers are never reused, even after an abort has put an earlier version
+// back, so tasks of an abandoned version cannot pass as current. The caller
+// must hold m.mu.
+func (m *Manager) nextVersionLocked(j *Job) int {
+	next := j.Version + 1
+	for _, v := range m.VersionDB[j.ID] {
+		next = max(next, v.Version+1)
+	}
+	return next
+}
+
+// applySpecLocked moves j to a new version with spec, and records it. For a
+// service, a template change is rolled out by a deployment and anything
+// else is applied to the running tasks in place. A batch job takes the new
+// spec for the tasks it places next. The caller must hold m.mu.
+func (m *Manager) applySpecLocked(j *Job, spec Job) {
+	j.Replicas = spec.Replicas
+	j.Update = spec.Update
+	j.UpdateTime = time.Now().UTC()
+	if j.Type == JobTypeBatch {
+		// Tasks already placed finish as they are; only the ones placed
+		// from now on follow the new spec.
+		j.Template = spec.Template
+		j.Completions = spec.Completions
+		j.Parallelism = spec.Parallelism
+		j.BackoffLimit = spec.BackoffLimit
+		j.Periodic = spec.Periodic
+		j.Version = m.nextVersionLocked(j)
+		m.touchLocked(j)
+	} else if templateChanged(j.Template, spec.Template) {
+		m.startDeploymentLocked(j, spec.Template, j.Update)
+	} else {
+		m.updateInPlaceLocked(j)
+	}
+	m.recordVersionLocked(j)
+}
+
+// updateInPlaceLocked bumps j's version without replacing its tasks: the
+// tasks of the current version, and any deployment rolling it out, move to
+// the new version with it. The caller must hold m.mu.
+func (m *Manager) updateInPlaceLocked(j *Job) {
+	next := m.nextVersionLocked(j)
+	for _, t := range m.TaskDB {
+		if t.JobID == j.ID && t.JobVersion == j.Version {
+			t.JobVersion = next
+		}
+	}
+	for _, d := range m.DeploymentDB {
+		if d.JobID == j.ID && d.Status == DeploymentRunning && d.JobVersion == j.Version {
+			d.JobVersion = next
+		}
+	}
+	j.Version = next
+	m.touchLocked(j)
+}
+
+// GetJobVersions returns the history of a job, oldest first.
+func (m *Manager) GetJobVersions(id uuid.UUID) ([]JobVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.JobDB[id]; !ok {
+		return nil, ErrJobNotFound
+	}
+	return slices.Clone(m.VersionDB[id]), nil
+}
+
+// GetJobVersion returns one version of a job.
+func (m *Manager) GetJobVersion(id uuid.UUID, version int) (JobVersion, error) {
+	versions, err := m.GetJobVersions(id)
+	if err != nil {
+		return JobVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return JobVersion{}, fmt.Errorf("%w: version %d", ErrVersionNotFound, version)
+}
+
+// RevertJob redeploys an earlier version of a job's spec. The revert is a
+// new version and rolls out with the job's current update strategy, not
+// the one the earlier version had.
+func (m *Manager) RevertJob(id uuid.UUID, version int) (*Job, error) {
+	target, err := m.GetJobVersion(id, version)
+	if err != nil {
+		return nil, err
+	}
+	current, ok := m.GetJob(id)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if current.Version == version {
+		return nil, fmt.Errorf("%w: job is already at version %d", ErrInvalidJobSpec, version)
+	}
+
+	spec := target.Spec
+	spec.Update = current.Update
+	logger.Info("reverting job", "job_id", id, "from", current.Version, "to", version)
+	return m.UpdateJob(id, spec)
+}
+
+// specOf strips everything from j that the manager sets rather than the
+// submitter, leaving only what is compared and stored between versions.
+func specOf(j Job) Job {
+	return Job{
+		Name:         j.Name,
+		Type:         j.Type,
+		Replicas:     j.Replicas,
+		Template:     templateSpec(j.Template),
+		Update:       j.Update,
+		Completions:  j.Completions,
+		Parallelism:  j.Parallelism,
+		BackoffLimit: j.BackoffLimit,
+		Periodic:     j.Periodic,
+	}
+}
+
+// templateSpec strips the runtime fields from a task template.
+func templateSpec(t task.Task) task.Task {
+	t.ID = uuid.Nil
+	t.JobID = uuid.Nil
+	t.JobVersion = 0
+	t.ContainerID = ""
+	t.State = task.Pending
+	t.HostPorts = nil
+	t.PortBindings = nil
+	t.AllocIndex = 0
+	t.AllocID = uuid.Nil
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.RestartCount = 0
+	t.Status = task.Status{}
+	t.Reason = ""
+	if t.Sidecars != nil {
+		t.Sidecars = slices.Clone(t.Sidecars)
+		for i := range t.Sidecars {
+			t.Sidecars[i].ContainerID = ""
+		}
+	}
+	return t
+}
+
+func templateChanged(current, next task.Task) bool {
+	return len(diffValues(templateSpec(current), templateSpec(next))) > 0
+}
+
+// DiffJobs lists the spec fields that differ between two versions of a job.
+func DiffJobs(from, to Job) []FieldDiff {
+	return diffValues(specOf(from), specOf(to))
+}
+
+func diffValues(from, to any) []FieldDiff {
+	a := make(map[string]string)
+	b := make(map[string]string)
+	flatten("", reflect.ValueOf(from), a)
+	flatten("", reflect.ValueOf(to), b)
+
+	fields := make([]string, 0, len(a)+len(b))
+	for f := range a {
+		fields = append(fields, f)
+	}
+	for f := range b {
+		if _, ok := a[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	slices.Sort(fields)
+
+	var diffs []FieldDiff
+	for _, f := range fields {
+		old, hadOld := a[f]
+		cur, hasNew := b[f]
+		switch {
+		case !hadOld:
+			diffs = append(diffs, FieldDiff{Field: f, Type: DiffAdded, New: cur})
+		case !hasNew:
+			diffs = append(diffs, FieldDiff{Field: f, Type: DiffRemoved, Old: old})
+		case old != cur:
+			diffs = append(diffs, FieldDiff{Field: f, Type: DiffEdited, Old: old, New: cur})
+		}
+	}
+	return diffs
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// flatten writes every non-zero leaf of v into out, keyed by its path.
+// Zero values are left out so that a field that is unset and one that is
+// set to its zero value compare equal.
+func flatten(path string, v reflect.Value, out map[string]string) {
+	switch {
+	case v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface:
+		if !v.IsNil() {
+			flatten(path, v.Elem(), out)
+		}
+	case v.Type() == timeType:
+		if t := v.Interface().(time.Time); !t.IsZero() {
+			out[path] = t.Format(time.RFC3339)
+		}
+	case v.Kind() == reflect.Struct:
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if f.IsExported() {
+				flatten(joinPath(path, f.Name), v.Field(i), out)
+			}
+		}
+	case v.Kind() == reflect.Map:
+		for _, k := range v.MapKeys() {
+			p := fmt.Sprintf("%s[%v]", path, k.Interface())
+			n := len(out)
+			flatten(p, v.MapIndex(k), out)
+			if len(out) == n {
+				// Set members such as nat.PortSet carry no value of their own.
+				out[p] = "set"
+			}
+		}
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array && v.Type().Elem().Kind() != reflect.Uint8:
+		for i := range v.Len() {
+			flatten(fmt.Sprintf("%s[%d]", path, i), v.Index(i), out)
+		}
+	default:
+		if !v.IsZero() {
+			out[path] = fmt.Sprint(v.Interface())
+		}
+	}
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return strings.Join([]string{prefix, name}, ".")
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJobVersionsAndRevert(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestJob(t, m, 2)
+	m.reconcileJobs()
+	markHealthy(m, j.ID)
+
+	// Scaling is applied in place: a new version, but no deployment and the
+	// running tasks move to it.
+	scaled, err := m.ScaleJob(j.ID, 3)
+	if err != nil {
+		t.Fatalf("ScaleJob: unexpected error: %v", err)
+	}
+	if scaled.Version != 1 || len(m.GetDeployments(j.ID)) != 0 {
+		t.Fatalf("after scale version = %d with %d deployments, want 1 and none", scaled.Version, len(m.GetDeployments(j.ID)))
+	}
+	if got := countVersions(m, j.ID); got[1] != 2 {
+		t.Fatalf("after scale versions = %v, want both tasks at version 1", got)
+	}
+
+	spec := *scaled
+	spec.Template.Image = "strm/helloworld-http:v2"
+	spec.Update.MaxParallel = 2
+	if _, err := m.UpdateJob(j.ID, spec); err != nil {
+		t.Fatalf("UpdateJob: unexpected error: %v", err)
+	}
+	// Submitting the same spec again is not a new version.
+	if _, err := m.UpdateJob(j.ID, spec); err != nil {
+		t.Fatalf("UpdateJob: unexpected error: %v", err)
+	}
+
+	versions, _ := m.GetJobVersions(j.ID)
+	if len(versions) != 3 {
+		t.Fatalf("got %d versions, want 3", len(versions))
+	}
+	diff := DiffJobs(versions[0].Spec, versions[2].Spec)
+	want := []FieldDiff{
+		{Field: "Replicas", Type: DiffEdited, Old: "2", New: "3"},
+		{Field: "Template.Image", Type: DiffEdited, Old: "strm/helloworld-http", New: "strm/helloworld-http:v2"},
+		{Field: "Update.MaxParallel", Type: DiffEdited, Old: "1", New: "2"},
+	}
+	if len(diff) != len(want) {
+		t.Fatalf("diff = %+v, want %+v", diff, want)
+	}
+	for i := range want {
+		if diff[i] != want[i] {
+			t.Errorf("diff[%d] = %+v, want %+v", i, diff[i], want[i])
+		}
+	}
+
+	if _, err := m.RevertJob(j.ID, 2); err == nil {
+		t.Fatal("RevertJob to the current version: expected an error")
+	}
+	reverted, err := m.RevertJob(j.ID, 0)
+	if err != nil {
+		t.Fatalf("RevertJob: unexpected error: %v", err)
+	}
+	if reverted.Version != 3 || reverted.Replicas != 2 || reverted.Template.Image != "strm/helloworld-http" {
+		t.Fatalf("reverted job = version %d, %d replicas, image %q", reverted.Version, reverted.Replicas, reverted.Template.Image)
+	}
+	if reverted.Update.MaxParallel != 2 {
+		t.Errorf("reverted job MaxParallel = %d, want the current strategy's 2", reverted.Update.MaxParallel)
+	}
+	if d, ok := m.activeDeployment(j.ID); !ok || d.JobVersion != 3 {
+		t.Fatalf("revert did not start a deployment of version 3: %+v", d)
+	}
+}
+
+func TestBatchJobUpdateAndRevert(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 3)
+	j := newTestBatchJob(t, m, 3, 1, 0)
+	m.reconcileJobs()
+
+	spec := *j
+	spec.Parallelism = 2
+	spec.Template.Image = "busybox:1.36"
+	updated, err := m.UpdateJob(j.ID, spec)
+	if err != nil {
+		t.Fatalf("UpdateJob: unexpected error: %v", err)
+	}
+	if updated.Version != 1 || updated.Parallelism != 2 || len(m.GetDeployments(j.ID)) != 0 {
+		t.Fatalf("updated job = version %d, parallelism %d, %d deployments", updated.Version, updated.Parallelism, len(m.GetDeployments(j.ID)))
+	}
+
+	// The task already placed keeps its version and image; the next one
+	// gets the new template.
+	m.reconcileJobs()
+	got := countVersions(m, j.ID)
+	if got[0] != 1 || got[1] != 1 {
+		t.Errorf("task versions = %v, want one of each", got)
+	}
+
+	reverted, err := m.RevertJob(j.ID, 0)
+	if err != nil {
+		t.Fatalf("RevertJob: unexpected error: %v", err)
+	}
+	if reverted.Version != 2 || reverted.Parallelism != 1 || reverted.Template.Image != "busybox" {
+		t.Errorf("reverted job = version %d, parallelism %d, image %q", reverted.Version, reverted.Parallelism, reverted.Template.Image)
+	}
+
+	spec.Periodic = &PeriodicConfig{Schedule: "@hourly"}
+	if _, err := m.UpdateJob(j.ID, spec); !errors.Is(err, ErrInvalidJobSpec) {
+		t.Errorf("making the job periodic: err = %v, want ErrInvalidJobSpec", err)
+	}
+}
@@ -0,0 +1,316 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// Workflow is a set of tasks submitted together whose DependsOn entries form
+// a DAG. Tasks are held in Pending until every dependency meets its
+// condition, and fail without running when a dependency fails for good.
+type Workflow struct {
+	ID             uuid.UUID
+	Name           string
+	State          JobState
+	Nodes          []WorkflowNode
+	CreateTime     time.Time
+	CompletionTime time.Time
+}
+
+// WorkflowNode is one task of a workflow as of the last reconciliation pass.
+type WorkflowNode struct {
+	Name       string
+	TaskID     uuid.UUID
+	State      task.State
+	DependsOn  []task.Dependency
+	Dispatched bool
+	Reason     string
+}
+
+// WorkflowSpec is a workflow submission.
+type WorkflowSpec struct {
+	Name  string
+	Tasks []task.Task
+}
+
+var (
+	ErrWorkflowNotFound = errors.New("workflow not found")
+	ErrInvalidWorkflow  = errors.New("invalid workflow")
+)
+
+// AddWorkflow validates the graph, stores every task in TaskDB as Pending and
+// queues those without dependencies. The rest are released by the
+// reconciler.
+func (m *Manager) AddWorkflow(spec WorkflowSpec) (*Workflow, error) {
+	if strings.TrimSpace(spec.Name) == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidWorkflow)
+	}
+	if len(spec.Tasks) == 0 {
+		return nil, fmt.Errorf("%w: at least one task is required", ErrInvalidWorkflow)
+	}
+	for _, t := range spec.Tasks {
+		if strings.TrimSpace(t.Image) == "" {
+			return nil, fmt.Errorf("%w: task %q has no image", ErrInvalidWorkflow, t.Name)
+		}
+		if err := task.Validate(t); err != nil {
+			return nil, fmt.Errorf("%w: task %q: %v", ErrInvalidWorkflow, t.Name, err)
+		}
+	}
+	sorted, err := task.SortByDependencies(spec.Tasks)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWorkflow, err)
+	}
+
+	wf := &Workflow{
+		ID:         uuid.New(),
+		Name:       spec.Name,
+		State:      JobRunning,
+		CreateTime: time.Now().UTC(),
+	}
+
+	ids := make(map[string]uuid.UUID, len(sorted))
+	var held, ready []task.Task
+	for _, t := range sorted {
+		name := t.Name
+		t.ID = uuid.New()
+		t.State = task.Pending
+		t.Name = fmt.Sprintf("%s-%s", spec.Name, name)
+		ids[name] = t.ID
+
+		// Dependencies always come earlier in sorted, so their IDs are known.
+		deps := make([]task.Dependency, len(t.DependsOn))
+		for i, d := range t.DependsOn {
+			if d.Condition == "" {
+				d.Condition = task.ConditionCompleted
+			}
+			d.TaskID = ids[d.Task]
+			deps[i] = d
+		}
+		t.DependsOn = deps
+
+		node := WorkflowNode{Name: name, TaskID: t.ID, State: task.Pending, DependsOn: deps}
+		if len(deps) == 0 {
+			node.Dispatched = true
+			ready = append(ready, t)
+		} else {
+			held = append(held, t)
+		}
+		wf.Nodes = append(wf.Nodes, node)
+	}
+
+	// Every task is in TaskDB before the workflow is published, so the
+	// reconciler never finds a dependency missing.
+	m.mu.Lock()
+	for _, t := range slices.Concat(ready, held) {
+		m.TaskDB[t.ID] = &t
+	}
+	m.WorkflowDB[wf.ID] = wf
+	m.mu.Unlock()
+
+	for _, t := range ready {
+		m.submitTask(t)
+	}
+
+	logger.Info("added workflow", "workflow_id", wf.ID, "name", wf.Name, "tasks", len(wf.Nodes))
+	return m.GetWorkflow(wf.ID)
+}
+
+// GetWorkflow returns a copy of the workflow with the given ID.
+func (m *Manager) GetWorkflow(id uuid.UUID) (*Workflow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	wf, ok := m.WorkflowDB[id]
+	if !ok {
+		return nil, ErrWorkflowNotFound
+	}
+	c := *wf
+	c.Nodes = append([]WorkflowNode(nil), wf.Nodes...)
+	return &c, nil
+}
+
+// GetWorkflows returns copies of all workflows.
+func (m *Manager) GetWorkflows() []*Workflow {
+	m.mu.RLock()
+	ids := make([]uuid.UUID, 0, len(m.WorkflowDB))
+	for id := range m.WorkflowDB {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+
+	workflows := make([]*Workflow, 0, len(ids))
+	for _, id := range ids {
+		if wf, err := m.GetWorkflow(id); err == nil {
+			workflows = append(workflows, wf)
+		}
+	}
+	return workflows
+}
+
+// failedForGood reports whether t has failed and will not be restarted,
+// either because it has used up its restarts or because it never reached a
+// worker.
+func (m *Manager) failedForGood(t *task.Task) bool {
+	if t.State != task.Failed {
+		return false
+	}
+	_, dispatched := m.GetTaskWorker(t.ID)
+	return !dispatched || t.RestartCount >= m.MaxRestarts
+}
+
+// stoppedEarly reports whether t was stopped rather than exiting 0. It is
+// Completed all the same, but has no successful status.
+func stoppedEarly(t *task.Task) bool {
+	return t.State == task.Completed && !t.Status.Succeeded()
+}
+
+// dependencyStatus reports whether dep's condition is met by t, or can no
+// longer be met. A dependency that was stopped is broken.
+func (m *Manager) dependencyStatus(dep task.Dependency, t *task.Task) (met bool, broken bool) {
+	if m.failedForGood(t) || stoppedEarly(t) {
+		return false, true
+	}
+	switch dep.Condition {
+	case task.ConditionStarted:
+		return t.State == task.Running || t.State == task.Completed, false
+	case task.ConditionHealthy:
+		if t.State == task.Completed {
+			return true, false
+		}
+		healthy, checked := m.lastHealth(t.ID)
+		return t.State == task.Running && checked && healthy, false
+	default:
+		return t.State == task.Completed, false
+	}
+}
+
+func (m *Manager) ReconcileWorkflows(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logger.Info("reconciling workflows")
+			m.reconcileWorkflows()
+		}
+	}
+}
+
+func (m *Manager) reconcileWorkflows() {
+	for _, wf := range m.GetWorkflows() {
+		if wf.State == JobRunning {
+			m.reconcileWorkflow(wf)
+		}
+	}
+}
+
+// reconcileWorkflow releases held tasks whose dependencies are met, fails
+// those whose dependencies are broken, and settles the workflow once every
+// task has finished. Nodes are visited in dependency order, so a failure
+// cascades through the whole graph in a single pass.
+func (m *Manager) reconcileWorkflow(wf *Workflow) {
+	tasks := make(map[uuid.UUID]*task.Task, len(wf.Nodes))
+	for _, n := range wf.Nodes {
+		m.mu.RLock()
+		if t, ok := m.TaskDB[n.TaskID]; ok {
+			c := *t
+			tasks[n.TaskID] = &c
+		}
+		m.mu.RUnlock()
+	}
+
+	var release []task.Task
+	finished, succeeded := 0, 0
+	for i := range wf.Nodes {
+		n := &wf.Nodes[i]
+		t, ok := tasks[n.TaskID]
+		if !ok {
+			continue
+		}
+
+		if !n.Dispatched && t.State == task.Pending {
+			met, broken := m.dependenciesStatus(n, tasks)
+			switch {
+			case broken != "":
+				n.Reason = fmt.Sprintf("dependency %q failed", broken)
+				m.failHeldTask(t.ID, n.Reason)
+				t.State = task.Failed
+			case met:
+				n.Dispatched = true
+				n.Reason = ""
+				release = append(release, *t)
+			default:
+				n.Reason = "waiting for dependencies"
+			}
+		}
+
+		n.State = t.State
+		switch {
+		case t.State == task.Completed && t.Status.Succeeded():
+			finished++
+			succeeded++
+		case stoppedEarly(t), m.failedForGood(t):
+			finished++
+		}
+	}
+
+	for _, t := range release {
+		logger.Info("dependencies met, releasing task", "workflow_id", wf.ID, "task_id", t.ID)
+		m.submitTask(t)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.WorkflowDB[wf.ID]
+	if !ok {
+		return
+	}
+	stored.Nodes = wf.Nodes
+	if finished == len(wf.Nodes) {
+		stored.State = JobSucceeded
+		if succeeded < finished {
+			stored.State = JobFailed
+		}
+		stored.CompletionTime = time.Now().UTC()
+		logger.Info("workflow finished", "workflow_id", wf.ID, "state", stored.State)
+	}
+}
+
+// dependenciesStatus reports whether all of a node's dependencies are met,
+// or the name of one that never can be.
+func (m *Manager) dependenciesStatus(n *WorkflowNode, tasks map[uuid.UUID]*task.Task) (bool, string) {
+	met := true
+	for _, d := range n.DependsOn {
+		dt, ok := tasks[d.TaskID]
+		if !ok {
+			return false, d.Task
+		}
+		ok, broken := m.dependencyStatus(d, dt)
+		if broken {
+			return false, d.Task
+		}
+		met = met && ok
+	}
+	return met, ""
+}
+
+func (m *Manager) failHeldTask(id uuid.UUID, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.TaskDB[id]
+	if !ok || !task.ValidStateTransition(t.State, task.Failed) {
+		return
+	}
+	m.setStateLocked(t, task.Failed, SourceManager, reason)
+	t.FinishTime = time.Now().UTC()
+	logger.Warn("failing held task", "task_id", id, "reason", reason)
+}
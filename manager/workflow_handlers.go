@@ -0,0 +1,58 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+func writeWorkflowError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrWorkflowNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidWorkflow):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (a *API) CreateWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	spec := WorkflowSpec{}
+	if err := d.Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling workflow: %v", err))
+		return
+	}
+
+	wf, err := a.Manager.AddWorkflow(spec)
+	if err != nil {
+		writeWorkflowError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, wf)
+}
+
+func (a *API) GetWorkflowsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.GetWorkflows())
+}
+
+func (a *API) GetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "workflowID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid workflow ID: %v", err))
+		return
+	}
+	wf, err := a.Manager.GetWorkflow(id)
+	if err != nil {
+		writeWorkflowError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
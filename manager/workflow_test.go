@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/ctfrancia/mongeta/task"
+)
+
+func setNodeState(m *Manager, wf *Workflow, name string, state task.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, n := range wf.Nodes {
+		if n.Name == name {
+			m.TaskDB[n.TaskID].State = state
+			m.TaskWorkerMap[n.TaskID] = "w1:8080"
+			if state == task.Completed {
+				m.TaskDB[n.TaskID].Status = task.Status{Reason: "exited successfully"}
+			}
+		}
+	}
+}
+
+func nodeState(t *testing.T, m *Manager, wf *Workflow, name string) task.State {
+	t.Helper()
+	got, err := m.GetWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("GetWorkflow: unexpected error: %v", err)
+	}
+	for _, n := range got.Nodes {
+		if n.Name == name {
+			return n.State
+		}
+	}
+	t.Fatalf("no node %q", name)
+	return 0
+}
+
+func TestWorkflowHoldsAndCascades(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 0)
+	wf, err := m.AddWorkflow(WorkflowSpec{
+		Name: "release",
+		Tasks: []task.Task{
+			{Name: "build", Image: "builder"},
+			{Name: "test", Image: "tester", DependsOn: []task.Dependency{{Task: "build"}}},
+			{Name: "deploy", Image: "deployer", DependsOn: []task.Dependency{{Task: "test"}}},
+			{Name: "notify", Image: "notifier", DependsOn: []task.Dependency{{Task: "build", Condition: task.ConditionStarted}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddWorkflow: unexpected error: %v", err)
+	}
+	if got := len(m.Pending); got != 1 {
+		t.Fatalf("queued events = %d, want only the root", got)
+	}
+
+	setNodeState(m, wf, "build", task.Running)
+	m.reconcileWorkflows()
+	if got := len(m.Pending); got != 2 {
+		t.Fatalf("queued events = %d, want notify released once build started", got)
+	}
+
+	setNodeState(m, wf, "build", task.Completed)
+	m.reconcileWorkflows()
+	setNodeState(m, wf, "test", task.Failed)
+	setNodeState(m, wf, "notify", task.Completed)
+	m.reconcileWorkflows()
+
+	if got := nodeState(t, m, wf, "deploy"); got != task.Failed {
+		t.Errorf("deploy state = %v, want Failed by cascade", got)
+	}
+	got, _ := m.GetWorkflow(wf.ID)
+	if got.State != JobFailed {
+		t.Errorf("workflow state = %s, want failed", got.State)
+	}
+}
+
+func TestWorkflowStoppedDependency(t *testing.T) {
+	m := New([]string{"w1:8080"}, 100, 0)
+	wf, err := m.AddWorkflow(WorkflowSpec{
+		Name: "release",
+		Tasks: []task.Task{
+			{Name: "build", Image: "builder"},
+			{Name: "deploy", Image: "deployer", DependsOn: []task.Dependency{{Task: "build"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddWorkflow: unexpected error: %v", err)
+	}
+
+	// A stopped task is Completed without having exited 0.
+	for _, n := range wf.Nodes {
+		if n.Name == "build" {
+			m.mu.RLock()
+			build := *m.TaskDB[n.TaskID]
+			m.mu.RUnlock()
+			m.stopTask(build, SourceUser, "stopped by user")
+		}
+	}
+	m.reconcileWorkflows()
+
+	if got := nodeState(t, m, wf, "deploy"); got != task.Failed {
+		t.Errorf("deploy state = %v, want Failed", got)
+	}
+	got, _ := m.GetWorkflow(wf.ID)
+	if got.State != JobFailed {
+		t.Errorf("workflow state = %s, want failed", got.State)
+	}
+}
@@ -0,0 +1,32 @@
+// Package metrics writes the manager's and workers' metrics in the
+// Prometheus text format, so they can be scraped without a client library.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type Kind string
+
+const (
+	Gauge   Kind = "gauge"
+	Counter Kind = "counter"
+)
+
+// Metric is a single unlabelled sample.
+type Metric struct {
+	Name  string
+	Kind  Kind
+	Help  string
+	Value uint64
+}
+
+// Write sends metrics to w as a 200 response.
+func Write(w http.ResponseWriter, metrics []Metric) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.Name, m.Help, m.Name, m.Kind, m.Name, m.Value)
+	}
+}
@@ -0,0 +1,213 @@
+// Package secrets is the manager's store of named secrets. Values are
+// sealed with AES-256-GCM as soon as they are stored and only opened when a
+// task that references them is dispatched, so neither memory dumps of the
+// store nor its file on disk hold them in the clear.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the length of the encryption key in bytes.
+const KeySize = 32
+
+var (
+	ErrNotFound    = errors.New("secret not found")
+	ErrInvalidName = errors.New("invalid secret name")
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Meta describes a stored secret without its value.
+type Meta struct {
+	Name       string
+	CreateTime time.Time
+	UpdateTime time.Time
+}
+
+type entry struct {
+	Meta
+	Sealed []byte
+}
+
+// Store holds secrets encrypted under one key. If it has a path, every
+// change is written there, still encrypted.
+type Store struct {
+	aead    cipher.AEAD
+	path    string
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+// ParseKey decodes a base64 key as given in config.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// NewKey returns a random key.
+func NewKey() []byte {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	return key
+}
+
+// New returns a store encrypting with key. A non-empty path is loaded if it
+// exists and kept up to date afterwards.
+func New(key []byte, path string) (*Store, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{aead: aead, path: path, entries: make(map[string]*entry)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets file: %w", err)
+	}
+	var entries []*entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing secrets file: %w", err)
+	}
+	for _, e := range entries {
+		// Fail now rather than at dispatch if the key does not match.
+		if _, err := s.open(e); err != nil {
+			return nil, fmt.Errorf("cannot decrypt secret %q, wrong key?", e.Name)
+		}
+		s.entries[e.Name] = e
+	}
+	return s, nil
+}
+
+// NewMemory returns a store kept only in memory, encrypting with a random
+// key. Its secrets do not survive a restart.
+func NewMemory() *Store {
+	aead, err := newAEAD(NewKey())
+	if err != nil {
+		// A key of KeySize bytes is always valid.
+		panic(err)
+	}
+	return &Store{aead: aead, entries: make(map[string]*entry)}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Put stores value under name, replacing any earlier value. It reports
+// whether the secret is new.
+func (s *Store) Put(name string, value []byte) (bool, error) {
+	if !nameRe.MatchString(name) {
+		return false, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	rand.Read(nonce)
+	sealed := s.aead.Seal(nonce, nonce, value, []byte(name))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	e, ok := s.entries[name]
+	if !ok {
+		e = &entry{Meta: Meta{Name: name, CreateTime: now}}
+		s.entries[name] = e
+	}
+	e.Sealed = sealed
+	e.UpdateTime = now
+	return !ok, s.saveLocked()
+}
+
+// Get returns the decrypted value of a secret.
+func (s *Store) Get(name string) ([]byte, error) {
+	s.mu.RLock()
+	e, ok := s.entries[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	return s.open(e)
+}
+
+// List returns the metadata of every secret, sorted by name.
+func (s *Store) List() []Meta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	metas := make([]Meta, 0, len(s.entries))
+	for _, e := range s.entries {
+		metas = append(metas, e.Meta)
+	}
+	slices.SortFunc(metas, func(a, b Meta) int { return strings.Compare(a.Name, b.Name) })
+	return metas
+}
+
+// Delete removes a secret.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	delete(s.entries, name)
+	return s.saveLocked()
+}
+
+func (s *Store) open(e *entry) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(e.Sealed) < n {
+		return nil, fmt.Errorf("secret %q is corrupt", e.Name)
+	}
+	// The name is bound in as additional data so values cannot be swapped
+	// between entries in the file.
+	return s.aead.Open(nil, e.Sealed[:n], e.Sealed[n:], []byte(e.Name))
+}
+
+// saveLocked writes the sealed entries to the store's file, if it has one.
+// The caller must hold s.mu.
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("error writing secrets file: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
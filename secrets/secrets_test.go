@@ -0,0 +1,50 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	key := NewKey()
+
+	s, err := New(key, path)
+	if err != nil {
+		t.Fatalf("New: unexpected error: %v", err)
+	}
+	if _, err := s.Put("db-password", []byte("hunter2")); err != nil {
+		t.Fatalf("Put: unexpected error: %v", err)
+	}
+	if _, err := s.Put("bad name", []byte("x")); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Put with invalid name: err = %v, want ErrInvalidName", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Fatal("secrets file holds the value in the clear")
+	}
+
+	reopened, err := New(key, path)
+	if err != nil {
+		t.Fatalf("New on existing file: unexpected error: %v", err)
+	}
+	got, err := reopened.Get("db-password")
+	if err != nil || string(got) != "hunter2" {
+		t.Fatalf("Get = %q, %v, want hunter2", got, err)
+	}
+
+	if _, err := New(NewKey(), path); err == nil {
+		t.Error("New with the wrong key: expected an error")
+	}
+
+	if err := reopened.Delete("db-password"); err != nil {
+		t.Fatalf("Delete: unexpected error: %v", err)
+	}
+	if _, err := reopened.Get("db-password"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+}
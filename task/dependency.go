@@ -0,0 +1,95 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Condition is what a dependency must reach before its dependents may start.
+type Condition string
+
+const (
+	// ConditionCompleted waits for the dependency to exit successfully.
+	ConditionCompleted Condition = "completed"
+	// ConditionStarted waits for the dependency's container to be running.
+	ConditionStarted Condition = "started"
+	// ConditionHealthy waits for the dependency to pass its health check.
+	ConditionHealthy Condition = "healthy"
+)
+
+// Dependency names a task of the same workflow that must satisfy Condition
+// first. Task is the name given in the submission; the manager resolves it
+// to TaskID.
+type Dependency struct {
+	Task      string
+	TaskID    uuid.UUID
+	Condition Condition
+}
+
+var ErrInvalidDAG = errors.New("invalid task graph")
+
+// SortByDependencies returns the tasks in an order in which every task comes
+// after the tasks it depends on. It fails if names are missing or repeated,
+// a dependency is unknown or has an unknown condition, or the graph has a
+// cycle.
+func SortByDependencies(tasks []Task) ([]Task, error) {
+	byName := make(map[string]int, len(tasks))
+	for i, t := range tasks {
+		if t.Name == "" {
+			return nil, fmt.Errorf("%w: task %d has no name", ErrInvalidDAG, i)
+		}
+		if _, ok := byName[t.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate task name %q", ErrInvalidDAG, t.Name)
+		}
+		byName[t.Name] = i
+	}
+
+	for _, t := range tasks {
+		for _, d := range t.DependsOn {
+			if _, ok := byName[d.Task]; !ok {
+				return nil, fmt.Errorf("%w: %q depends on unknown task %q", ErrInvalidDAG, t.Name, d.Task)
+			}
+			switch d.Condition {
+			case "", ConditionCompleted, ConditionStarted, ConditionHealthy:
+			default:
+				return nil, fmt.Errorf("%w: %q has unknown condition %q", ErrInvalidDAG, t.Name, d.Condition)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	mark := make([]int, len(tasks))
+	sorted := make([]Task, 0, len(tasks))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch mark[i] {
+		case visiting:
+			return fmt.Errorf("%w: cycle through %q", ErrInvalidDAG, tasks[i].Name)
+		case done:
+			return nil
+		}
+		mark[i] = visiting
+		for _, d := range tasks[i].DependsOn {
+			if err := visit(byName[d.Task]); err != nil {
+				return err
+			}
+		}
+		mark[i] = done
+		sorted = append(sorted, tasks[i])
+		return nil
+	}
+
+	for i := range tasks {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
@@ -0,0 +1,55 @@
+package task
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSortByDependencies(t *testing.T) {
+	tasks := []Task{
+		{Name: "deploy", DependsOn: []Dependency{{Task: "test"}, {Task: "build"}}},
+		{Name: "test", DependsOn: []Dependency{{Task: "build", Condition: ConditionCompleted}}},
+		{Name: "build"},
+	}
+	sorted, err := SortByDependencies(tasks)
+	if err != nil {
+		t.Fatalf("SortByDependencies: unexpected error: %v", err)
+	}
+
+	pos := make(map[string]int)
+	for i, task := range sorted {
+		pos[task.Name] = i
+	}
+	for _, task := range tasks {
+		for _, d := range task.DependsOn {
+			if pos[d.Task] > pos[task.Name] {
+				t.Errorf("%q sorted before its dependency %q", task.Name, d.Task)
+			}
+		}
+	}
+}
+
+func TestSortByDependenciesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []Task
+	}{
+		{"cycle", []Task{
+			{Name: "a", DependsOn: []Dependency{{Task: "b"}}},
+			{Name: "b", DependsOn: []Dependency{{Task: "a"}}},
+		}},
+		{"self", []Task{{Name: "a", DependsOn: []Dependency{{Task: "a"}}}}},
+		{"unknown", []Task{{Name: "a", DependsOn: []Dependency{{Task: "missing"}}}}},
+		{"duplicate", []Task{{Name: "a"}, {Name: "a"}}},
+		{"unnamed", []Task{{}}},
+		{"condition", []Task{
+			{Name: "a"},
+			{Name: "b", DependsOn: []Dependency{{Task: "a", Condition: "eventually"}}},
+		}},
+	}
+	for _, tt := range tests {
+		if _, err := SortByDependencies(tt.tasks); !errors.Is(err, ErrInvalidDAG) {
+			t.Errorf("%s: error = %v, want ErrInvalidDAG", tt.name, err)
+		}
+	}
+}
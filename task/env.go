@@ -0,0 +1,60 @@
+package task
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// RuntimeEnv returns the MONGETA_* variables that tell the containers of t
+// about the task and its allocation on the node named node:
+//
+//	MONGETA_TASK_ID, MONGETA_TASK_NAME, MONGETA_NODE_NAME
+//	MONGETA_ALLOC_INDEX   the index of the task within its job
+//	MONGETA_PORT_<label>  the host port bound to each port in PortBindings
+func RuntimeEnv(t *Task, node string) []string {
+	env := []string{
+		"MONGETA_TASK_ID=" + t.ID.String(),
+		"MONGETA_TASK_NAME=" + t.Name,
+		"MONGETA_NODE_NAME=" + node,
+		"MONGETA_ALLOC_INDEX=" + strconv.Itoa(t.AllocIndex),
+	}
+	labels := make(map[nat.Port]string, len(t.PortLabels))
+	for label, port := range t.PortLabels {
+		labels[port] = label
+	}
+	for _, port := range slices.Sorted(maps.Keys(t.PortBindings)) {
+		label, ok := labels[nat.Port(port)]
+		if !ok {
+			label = defaultPortLabel(nat.Port(port))
+		}
+		env = append(env, fmt.Sprintf("MONGETA_PORT_%s=%s", envName(label), t.PortBindings[port]))
+	}
+	return env
+}
+
+// defaultPortLabel names an unlabelled port after its number, adding the
+// protocol unless it is tcp: "8080", "53_udp".
+func defaultPortLabel(p nat.Port) string {
+	if p.Proto() == "tcp" {
+		return p.Port()
+	}
+	return p.Port() + "_" + p.Proto()
+}
+
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
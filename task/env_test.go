@@ -0,0 +1,30 @@
+package task
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestRuntimeEnv(t *testing.T) {
+	tk := Task{
+		ID:           uuid.MustParse("6f1d3c1e-0000-4000-8000-000000000001"),
+		Name:         "web-6f1d3c1e",
+		AllocIndex:   2,
+		PortLabels:   map[string]nat.Port{"http": "80/tcp"},
+		PortBindings: map[string]string{"80/tcp": "21000", "53/udp": "21001"},
+	}
+	want := []string{
+		"MONGETA_TASK_ID=6f1d3c1e-0000-4000-8000-000000000001",
+		"MONGETA_TASK_NAME=web-6f1d3c1e",
+		"MONGETA_NODE_NAME=w1:8080",
+		"MONGETA_ALLOC_INDEX=2",
+		"MONGETA_PORT_53_UDP=21001",
+		"MONGETA_PORT_HTTP=21000",
+	}
+	if got := RuntimeEnv(&tk, "w1:8080"); !slices.Equal(got, want) {
+		t.Errorf("RuntimeEnv =\n%q\nwant\n%q", got, want)
+	}
+}
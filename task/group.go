@@ -0,0 +1,82 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+// Container is an extra container of a task group. Init containers run one
+// after another to completion before the task's own container starts.
+// Sidecars start next to it, share its network namespace, and are stopped
+// once it exits.
+type Container struct {
+	Name   string
+	Image  string
+	Cmd    []string
+	Env    []string
+	CPU    float64
+	Memory int64
+	// ContainerID is set by the worker once a sidecar has been started.
+	ContainerID string
+}
+
+var ErrInvalidGroup = errors.New("invalid task group")
+
+// ValidateGroup checks the init containers and sidecars of t. Every
+// container needs an image and a name that is unique within the group.
+func ValidateGroup(t Task) error {
+	seen := make(map[string]bool)
+	check := func(kind string, cs []Container) error {
+		for i, c := range cs {
+			if strings.TrimSpace(c.Name) == "" {
+				return fmt.Errorf("%w: %s %d has no name", ErrInvalidGroup, kind, i)
+			}
+			if seen[c.Name] {
+				return fmt.Errorf("%w: duplicate container name %q", ErrInvalidGroup, c.Name)
+			}
+			seen[c.Name] = true
+			if strings.TrimSpace(c.Image) == "" {
+				return fmt.Errorf("%w: %s %q has no image", ErrInvalidGroup, kind, c.Name)
+			}
+		}
+		return nil
+	}
+	if err := check("init container", t.InitContainers); err != nil {
+		return err
+	}
+	return check("sidecar", t.Sidecars)
+}
+
+// NewContainerConfig builds the config of one extra container of t. Its
+// Docker name is the task's name followed by the container's.
+func NewContainerConfig(t *Task, c Container) *Config {
+	return &Config{
+		Name:        fmt.Sprintf("%s-%s", t.Name, c.Name),
+		Image:       c.Image,
+		Cmd:         c.Cmd,
+		Env:         c.Env,
+		CPU:         c.CPU,
+		Memory:      c.Memory,
+		StopSignal:  t.StopSignal,
+		StopTimeout: t.StopTimeout,
+	}
+}
+
+// Wait blocks until the container is no longer running and returns its exit
+// code.
+func (d *Docker) Wait(ctx context.Context, containerID string) (int64, error) {
+	resultC, errC := d.Client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case res := <-resultC:
+		if res.Error != nil {
+			return res.StatusCode, errors.New(res.Error.Message)
+		}
+		return res.StatusCode, nil
+	case err := <-errC:
+		return 0, err
+	}
+}
@@ -0,0 +1,182 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidLabels   = errors.New("invalid labels")
+	ErrInvalidSelector = errors.New("invalid selector")
+)
+
+// A label key is a name of up to 63 characters, optionally after a DNS
+// prefix and a slash, such as "example.com/team". A value is a name or
+// empty.
+var (
+	labelNameRe   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?$`)
+	labelPrefixRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+)
+
+// ValidLabelKey reports whether k may be used as a label or annotation key.
+func ValidLabelKey(k string) bool {
+	prefix, name, ok := strings.Cut(k, "/")
+	if !ok {
+		return labelNameRe.MatchString(k)
+	}
+	return labelPrefixRe.MatchString(prefix) && labelNameRe.MatchString(name)
+}
+
+// ValidLabelValue reports whether v may be used as a label value.
+func ValidLabelValue(v string) bool {
+	return v == "" || labelNameRe.MatchString(v)
+}
+
+// ValidateLabels checks the keys of t's labels and annotations and the
+// values of its labels. Annotation values may be anything.
+func ValidateLabels(t Task) error {
+	for _, k := range slices.Sorted(maps.Keys(t.Labels)) {
+		if !ValidLabelKey(k) {
+			return fmt.Errorf("%w: label key %q", ErrInvalidLabels, k)
+		}
+		if !ValidLabelValue(t.Labels[k]) {
+			return fmt.Errorf("%w: label %q value %q", ErrInvalidLabels, k, t.Labels[k])
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(t.Annotations)) {
+		if !ValidLabelKey(k) {
+			return fmt.Errorf("%w: annotation key %q", ErrInvalidLabels, k)
+		}
+	}
+	return nil
+}
+
+// Selector matches labels against every one of its requirements. It is
+// written as a comma-separated list such as
+//
+//	env=prod,tier!=cache,team in (a,b),release notin (v1),canary,!legacy
+//
+// where a bare key requires the label to be set and !key to be unset. The
+// empty selector matches everything.
+type Selector []Requirement
+
+type Requirement struct {
+	Key    string
+	Op     SelectorOp
+	Values []string
+}
+
+type SelectorOp string
+
+const (
+	OpEquals    SelectorOp = "="
+	OpNotEquals SelectorOp = "!="
+	OpIn        SelectorOp = "in"
+	OpNotIn     SelectorOp = "notin"
+	OpExists    SelectorOp = "exists"
+	OpNotExists SelectorOp = "!"
+)
+
+var setRequirementRe = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+// ParseSelector parses a selector such as "env=prod,team in (a,b)".
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return nil, fmt.Errorf("%w: empty requirement in %q", ErrInvalidSelector, s)
+		}
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitSelector splits s at the commas outside parentheses.
+func splitSelector(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	var r Requirement
+	switch {
+	case setRequirementRe.MatchString(term):
+		m := setRequirementRe.FindStringSubmatch(term)
+		r = Requirement{Key: m[1], Op: SelectorOp(m[2])}
+		for _, v := range strings.Split(m[3], ",") {
+			r.Values = append(r.Values, strings.TrimSpace(v))
+		}
+	case strings.Contains(term, "!="):
+		k, v, _ := strings.Cut(term, "!=")
+		r = Requirement{Key: strings.TrimSpace(k), Op: OpNotEquals, Values: []string{strings.TrimSpace(v)}}
+	case strings.Contains(term, "="):
+		k, v, _ := strings.Cut(term, "=")
+		v = strings.TrimPrefix(v, "=")
+		r = Requirement{Key: strings.TrimSpace(k), Op: OpEquals, Values: []string{strings.TrimSpace(v)}}
+	case strings.HasPrefix(term, "!"):
+		r = Requirement{Key: strings.TrimSpace(term[1:]), Op: OpNotExists}
+	default:
+		r = Requirement{Key: term, Op: OpExists}
+	}
+	if !ValidLabelKey(r.Key) {
+		return Requirement{}, fmt.Errorf("%w: invalid key %q in %q", ErrInvalidSelector, r.Key, term)
+	}
+	for _, v := range r.Values {
+		if !ValidLabelValue(v) {
+			return Requirement{}, fmt.Errorf("%w: invalid value %q in %q", ErrInvalidSelector, v, term)
+		}
+	}
+	return r, nil
+}
+
+// Matches reports whether labels meet every requirement of s.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.Key]
+		var match bool
+		switch r.Op {
+		case OpEquals:
+			match = ok && v == r.Values[0]
+		case OpNotEquals:
+			match = !ok || v != r.Values[0]
+		case OpIn:
+			match = ok && slices.Contains(r.Values, v)
+		case OpNotIn:
+			match = !ok || !slices.Contains(r.Values, v)
+		case OpExists:
+			match = ok
+		case OpNotExists:
+			match = !ok
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
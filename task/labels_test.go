@@ -0,0 +1,34 @@
+package task
+
+import "testing"
+
+func TestSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "b", "example.com/canary": ""}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod,team in (a, b)", true},
+		{"env!=prod", false},
+		{"team notin (a,b)", false},
+		{"example.com/canary,!legacy", true},
+		{"legacy", false},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): unexpected error: %v", tt.selector, err)
+		}
+		if got := sel.Matches(labels); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"env=prod,", "team in (a,b", "-env=x", "env=a b"} {
+		if _, err := ParseSelector(bad); err == nil {
+			t.Errorf("ParseSelector(%q): expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package task
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query selects, orders and pages a list of tasks.
+type Query struct {
+	States []State
+	// Name and Image are path.Match patterns, such as "web-*".
+	Name  string
+	Image string
+	// Selector matches the tasks' labels.
+	Selector Selector
+	// Sort is the field to order by, prefixed with "-" for descending
+	// order. Tasks with equal fields are ordered by ID.
+	Sort string
+	// Limit caps the number of tasks returned; 0 returns them all.
+	Limit int
+	// Cursor is where the previous page ended.
+	Cursor string
+}
+
+// DefaultSort is the order tasks are listed in when Query.Sort is empty.
+const DefaultSort = "name"
+
+var sortFields = map[string]func(a, b *Task) int{
+	"name":        func(a, b *Task) int { return strings.Compare(a.Name, b.Name) },
+	"image":       func(a, b *Task) int { return strings.Compare(a.Image, b.Image) },
+	"state":       func(a, b *Task) int { return cmp.Compare(a.State, b.State) },
+	"start_time":  func(a, b *Task) int { return a.StartTime.Compare(b.StartTime) },
+	"finish_time": func(a, b *Task) int { return a.FinishTime.Compare(b.FinishTime) },
+}
+
+// cursor holds the ID and sort fields of the last task of a page.
+type cursor struct {
+	Sort       string
+	ID         uuid.UUID
+	Name       string    `json:",omitempty"`
+	Image      string    `json:",omitempty"`
+	State      State     `json:",omitempty"`
+	StartTime  time.Time `json:",omitzero"`
+	FinishTime time.Time `json:",omitzero"`
+}
+
+// ParseQuery reads a Query from the state, name, image, selector, sort,
+// limit and cursor parameters of a URL. States may be repeated or given as a
+// comma-separated list.
+func ParseQuery(v url.Values) (Query, error) {
+	q := Query{
+		Name:   v.Get("name"),
+		Image:  v.Get("image"),
+		Sort:   v.Get("sort"),
+		Cursor: v.Get("cursor"),
+	}
+	for _, s := range v["state"] {
+		for _, name := range strings.Split(s, ",") {
+			state, err := ParseState(name)
+			if err != nil {
+				return Query{}, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+			}
+			q.States = append(q.States, state)
+		}
+	}
+	for _, pattern := range []string{q.Name, q.Image} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Query{}, fmt.Errorf("%w: pattern %q: %v", ErrInvalidQuery, pattern, err)
+		}
+	}
+	sel, err := ParseSelector(v.Get("selector"))
+	if err != nil {
+		return Query{}, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	q.Selector = sel
+	if l := v.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return Query{}, fmt.Errorf("%w: limit %q", ErrInvalidQuery, l)
+		}
+		q.Limit = n
+	}
+	if _, _, err := q.compare(); err != nil {
+		return Query{}, err
+	}
+	if q.Cursor != "" {
+		if _, err := q.pivot(); err != nil {
+			return Query{}, err
+		}
+	}
+	return q, nil
+}
+
+// Matches reports whether t passes the filters of q.
+func (q Query) Matches(t *Task) bool {
+	if len(q.States) > 0 && !slices.Contains(q.States, t.State) {
+		return false
+	}
+	if ok, _ := path.Match(q.Name, t.Name); q.Name != "" && !ok {
+		return false
+	}
+	if ok, _ := path.Match(q.Image, t.Image); q.Image != "" && !ok {
+		return false
+	}
+	return q.Selector.Matches(t.Labels)
+}
+
+// Apply returns the page of tasks q selects and the cursor of the next
+// page, which is empty on the last one. It reorders tasks in place.
+func (q Query) Apply(tasks []Task) ([]Task, string, error) {
+	compare, sortName, err := q.compare()
+	if err != nil {
+		return nil, "", err
+	}
+	tasks = slices.DeleteFunc(tasks, func(t Task) bool { return !q.Matches(&t) })
+	slices.SortFunc(tasks, func(a, b Task) int { return compare(&a, &b) })
+
+	if q.Cursor != "" {
+		pivot, err := q.pivot()
+		if err != nil {
+			return nil, "", err
+		}
+		i, found := slices.BinarySearchFunc(tasks, pivot, func(t Task, p *Task) int { return compare(&t, p) })
+		if found {
+			i++
+		}
+		tasks = tasks[i:]
+	}
+
+	if q.Limit == 0 || len(tasks) <= q.Limit {
+		return tasks, "", nil
+	}
+	tasks = tasks[:q.Limit]
+	last := tasks[len(tasks)-1]
+	data, err := json.Marshal(cursor{
+		Sort:       sortName,
+		ID:         last.ID,
+		Name:       last.Name,
+		Image:      last.Image,
+		State:      last.State,
+		StartTime:  last.StartTime,
+		FinishTime: last.FinishTime,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return tasks, base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// compare returns the ordering q.Sort asks for and its normalised name.
+func (q Query) compare() (func(a, b *Task) int, string, error) {
+	name := cmp.Or(q.Sort, DefaultSort)
+	field, desc := strings.CutPrefix(name, "-")
+	byField, ok := sortFields[field]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, field)
+	}
+	return func(a, b *Task) int {
+		c := cmp.Or(byField(a, b), bytes.Compare(a.ID[:], b.ID[:]))
+		if desc {
+			return -c
+		}
+		return c
+	}, name, nil
+}
+
+// pivot decodes q.Cursor into a task that sorts where the previous page
+// ended.
+func (q Query) pivot() (*Task, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.Sort != cmp.Or(q.Sort, DefaultSort) {
+		return nil, fmt.Errorf("%w: cursor is for sort %q", ErrInvalidQuery, c.Sort)
+	}
+	return &Task{
+		ID:         c.ID,
+		Name:       c.Name,
+		Image:      c.Image,
+		State:      c.State,
+		StartTime:  c.StartTime,
+		FinishTime: c.FinishTime,
+	}, nil
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestQueryPages(t *testing.T) {
+	var tasks []Task
+	for _, name := range []string{"web-c", "db-a", "web-a", "web-b", "web-d"} {
+		tasks = append(tasks, Task{ID: uuid.New(), Name: name, State: Running})
+	}
+	tasks[0].State = Failed
+
+	q, err := ParseQuery(url.Values{"name": {"web-*"}, "state": {"Running"}, "sort": {"-name"}, "limit": {"2"}})
+	if err != nil {
+		t.Fatalf("ParseQuery: unexpected error: %v", err)
+	}
+	var names []string
+	for {
+		page, next, err := q.Apply(slices.Clone(tasks))
+		if err != nil {
+			t.Fatalf("Apply: unexpected error: %v", err)
+		}
+		for _, tk := range page {
+			names = append(names, tk.Name)
+		}
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	if want := []string{"web-d", "web-b", "web-a"}; !slices.Equal(names, want) {
+		t.Errorf("paged names = %v, want %v", names, want)
+	}
+
+	if _, err := ParseQuery(url.Values{"sort": {"name"}, "cursor": {q.Cursor}}); err == nil {
+		t.Error("ParseQuery with a cursor for another sort: expected an error")
+	}
+}
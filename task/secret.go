@@ -0,0 +1,35 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// SecretRef injects the manager-held secret Name into the task, either as
+// the environment variable Env or as a read-only file at the absolute path
+// File. Exactly one of the two must be set.
+type SecretRef struct {
+	Name string
+	Env  string
+	File string
+}
+
+var ErrInvalidSecretRef = errors.New("invalid secret reference")
+
+// ValidateSecrets checks the secret references of t.
+func ValidateSecrets(t Task) error {
+	for i, s := range t.Secrets {
+		if strings.TrimSpace(s.Name) == "" {
+			return fmt.Errorf("%w: secret %d has no name", ErrInvalidSecretRef, i)
+		}
+		if (s.Env == "") == (s.File == "") {
+			return fmt.Errorf("%w: secret %q needs exactly one of Env or File", ErrInvalidSecretRef, s.Name)
+		}
+		if s.File != "" && (!path.IsAbs(s.File) || path.Clean(s.File) != s.File) {
+			return fmt.Errorf("%w: secret %q file %q must be a clean absolute path", ErrInvalidSecretRef, s.Name, s.File)
+		}
+	}
+	return nil
+}
@@ -18,6 +18,12 @@ func (s State) String() string {
 		return "Completed"
 	case Failed:
 		return "Failed"
+	case Stopping:
+		return "Stopping"
+	case Restarting:
+		return "Restarting"
+	case Lost:
+		return "Lost"
 	default:
 		return "Unknown"
 	}
@@ -32,29 +38,54 @@ func (s *State) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &str); err != nil {
 		return err
 	}
-	switch str {
+	state, err := ParseState(str)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// ParseState returns the state with the given name, such as "Running".
+func ParseState(name string) (State, error) {
+	switch name {
 	case "Pending":
-		*s = Pending
+		return Pending, nil
 	case "Scheduled":
-		*s = Scheduled
+		return Scheduled, nil
 	case "Running":
-		*s = Running
+		return Running, nil
 	case "Completed":
-		*s = Completed
+		return Completed, nil
 	case "Failed":
-		*s = Failed
+		return Failed, nil
+	case "Stopping":
+		return Stopping, nil
+	case "Restarting":
+		return Restarting, nil
+	case "Lost":
+		return Lost, nil
+	case "Unknown":
+		return Unknown, nil
 	default:
-		return fmt.Errorf("unknown task state %q", str)
+		return 0, fmt.Errorf("unknown task state %q", name)
 	}
-	return nil
 }
 
+// A task stopped before it reaches a worker goes straight to Completed; one
+// stopped after it was sent goes to Stopping whether or not it has started.
 var stateTransitionMap = map[State][]State{
-	Pending:   {Scheduled},
-	Scheduled: {Scheduled, Running, Failed},
-	Running:   {Running, Completed, Failed},
-	Completed: {},
-	Failed:    {},
+	Pending:    {Scheduled, Completed, Failed},
+	Scheduled:  {Scheduled, Running, Stopping, Failed, Lost},
+	Running:    {Running, Stopping, Restarting, Completed, Failed, Lost, Unknown},
+	Stopping:   {Completed, Failed, Lost},
+	Restarting: {Scheduled, Running, Stopping, Completed, Failed, Lost},
+	Completed:  {},
+	Failed:     {Restarting},
+	// A Lost task's worker may come back and report on it, or it is
+	// restarted elsewhere.
+	Lost:    {Running, Stopping, Restarting, Completed, Failed},
+	Unknown: {Running, Stopping, Restarting, Completed, Failed, Lost},
 }
 
 func Contains(states []State, state State) bool {
@@ -64,3 +95,25 @@ func Contains(states []State, state State) bool {
 func ValidStateTransition(src State, dst State) bool {
 	return Contains(stateTransitionMap[src], dst)
 }
+
+// CanReach reports whether dst can follow src through one or more
+// transitions. The manager only hears from workers now and then, so it may
+// miss the states in between.
+func CanReach(src State, dst State) bool {
+	seen := map[State]bool{src: true}
+	next := []State{src}
+	for len(next) > 0 {
+		s := next[0]
+		next = next[1:]
+		for _, t := range stateTransitionMap[s] {
+			if t == dst {
+				return true
+			}
+			if !seen[t] {
+				seen[t] = true
+				next = append(next, t)
+			}
+		}
+	}
+	return false
+}
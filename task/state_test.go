@@ -15,6 +15,10 @@ func TestStateString(t *testing.T) {
 		{Running, "Running"},
 		{Completed, "Completed"},
 		{Failed, "Failed"},
+		{Stopping, "Stopping"},
+		{Restarting, "Restarting"},
+		{Lost, "Lost"},
+		{Unknown, "Unknown"},
 		{State(99), "Unknown"},
 	}
 	for _, tt := range tests {
@@ -54,6 +58,10 @@ func TestStateUnmarshalJSON(t *testing.T) {
 		{`"Running"`, Running},
 		{`"Completed"`, Completed},
 		{`"Failed"`, Failed},
+		{`"Stopping"`, Stopping},
+		{`"Restarting"`, Restarting},
+		{`"Lost"`, Lost},
+		{`"Unknown"`, Unknown},
 	}
 	for _, tt := range tests {
 		var s State
@@ -74,7 +82,7 @@ func TestStateUnmarshalJSONUnknown(t *testing.T) {
 }
 
 func TestStateMarshalRoundTrip(t *testing.T) {
-	for _, original := range []State{Pending, Scheduled, Running, Completed, Failed} {
+	for _, original := range []State{Pending, Scheduled, Running, Completed, Failed, Stopping, Restarting, Lost, Unknown} {
 		data, err := json.Marshal(original)
 		if err != nil {
 			t.Fatalf("Marshal(%v): %v", original, err)
@@ -92,12 +100,29 @@ func TestStateMarshalRoundTrip(t *testing.T) {
 func TestValidStateTransition(t *testing.T) {
 	valid := []struct{ src, dst State }{
 		{Pending, Scheduled},
+		{Pending, Failed},
+		{Pending, Completed},
 		{Scheduled, Scheduled},
+		{Scheduled, Stopping},
 		{Scheduled, Running},
 		{Scheduled, Failed},
 		{Running, Running},
 		{Running, Completed},
 		{Running, Failed},
+		{Running, Stopping},
+		{Stopping, Completed},
+		{Stopping, Failed},
+		{Running, Restarting},
+		{Failed, Restarting},
+		{Restarting, Scheduled},
+		{Restarting, Running},
+		{Restarting, Completed},
+		{Scheduled, Lost},
+		{Running, Lost},
+		{Lost, Running},
+		{Lost, Restarting},
+		{Running, Unknown},
+		{Unknown, Running},
 	}
 	for _, tt := range valid {
 		if !ValidStateTransition(tt.src, tt.dst) {
@@ -107,12 +132,15 @@ func TestValidStateTransition(t *testing.T) {
 
 	invalid := []struct{ src, dst State }{
 		{Pending, Running},
-		{Pending, Completed},
-		{Pending, Failed},
 		{Completed, Running},
 		{Completed, Scheduled},
 		{Failed, Running},
 		{Failed, Completed},
+		{Stopping, Running},
+		{Failed, Scheduled},
+		{Completed, Restarting},
+		{Pending, Lost},
+		{Lost, Scheduled},
 	}
 	for _, tt := range invalid {
 		if ValidStateTransition(tt.src, tt.dst) {
@@ -0,0 +1,52 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+// Status is how a task's container ended, as reported by Docker.
+type Status struct {
+	ExitCode   int
+	OOMKilled  bool
+	Error      string
+	FinishedAt time.Time
+	// Reason sums the above up for people, such as "OOM killed".
+	Reason string
+}
+
+// Failed reports whether the container ended unsuccessfully.
+func (s Status) Failed() bool {
+	return s.ExitCode != 0 || s.OOMKilled || s.Error != ""
+}
+
+// Succeeded reports whether the container exited 0 of its own accord. A
+// task stopped by mongeta has no status, so it has not succeeded.
+func (s Status) Succeeded() bool {
+	return s != (Status{}) && !s.Failed()
+}
+
+// NewStatus builds the status of a container that is no longer running.
+func NewStatus(s *container.State) Status {
+	st := Status{
+		ExitCode:  s.ExitCode,
+		OOMKilled: s.OOMKilled,
+		Error:     s.Error,
+	}
+	if finished, err := time.Parse(time.RFC3339Nano, s.FinishedAt); err == nil {
+		st.FinishedAt = finished.UTC()
+	}
+	switch {
+	case s.OOMKilled:
+		st.Reason = fmt.Sprintf("OOM killed (exit code %d)", s.ExitCode)
+	case s.Error != "":
+		st.Reason = "container error: " + s.Error
+	case s.ExitCode != 0:
+		st.Reason = fmt.Sprintf("exited with code %d", s.ExitCode)
+	default:
+		st.Reason = "exited successfully"
+	}
+	return st
+}
@@ -17,6 +17,15 @@ import (
 	"github.com/moby/moby/client"
 )
 
+// State is where a task is in its lifecycle:
+//
+//	Pending ──> Scheduled ──> Running ──> Stopping ──> Completed
+//	                 │           │  ╲                  Failed
+//	                 │           │   ╲──> Restarting ──> Scheduled, Running
+//	                 ╰───────────┴──> Lost, Unknown
+//
+// A Failed task can be restarted, which takes it back through Restarting.
+// See stateTransitionMap for every transition allowed.
 type State int
 
 const (
@@ -25,32 +34,103 @@ const (
 	Running
 	Completed
 	Failed
+	// Stopping is a task whose container has been asked to stop and is
+	// within its grace period.
+	Stopping
+	// Restarting is a task whose container is being replaced on its
+	// worker, after a failure or failed health check.
+	Restarting
+	// Lost is a task whose worker cannot be reached.
+	Lost
+	// Unknown is a task whose worker cannot tell the state of its
+	// container.
+	Unknown
 )
 
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
-	Image         string
-	CPU           float64
-	Memory        int64
-	Disk          int64
-	ExposedPorts  nat.PortSet
-	HostPorts     nat.PortMap
-	PortBindings  map[string]string
+	ID           uuid.UUID
+	JobID        uuid.UUID
+	JobVersion   int
+	ContainerID  string
+	Name         string
+	State        State
+	Image        string
+	CPU          float64
+	Memory       int64
+	Disk         int64
+	ExposedPorts nat.PortSet
+	HostPorts    nat.PortMap
+	// PortBindings maps each exposed port to the host port the worker
+	// allocated for it before creating the container.
+	PortBindings map[string]string
+	// PortLabels name exposed ports for the MONGETA_PORT_<label> variables.
+	PortLabels    map[string]nat.Port
 	RestartPolicy container.RestartPolicyMode
 	StartTime     time.Time
 	FinishTime    time.Time
 	HealthCheck   string
 	RestartCount  int
+	DependsOn     []Dependency
+	// AllocID is the manager's record of the task's current placement on
+	// a worker, which changes each time it is restarted.
+	AllocID uuid.UUID
+	// AllocIndex is the task's index among the live tasks of its job,
+	// the lowest not in use when it was submitted.
+	AllocIndex int
+
+	// Labels group tasks, such as by team or environment, for selectors
+	// to pick them out; see Selector. Annotations hold other metadata.
+	Labels      map[string]string
+	Annotations map[string]string
+
+	// MaxRunDuration is how long the container may run before the worker
+	// kills it and fails the task. ScheduleDeadline is how long the manager
+	// may take to place the task before failing it instead. Zero means no
+	// limit.
+	MaxRunDuration   time.Duration
+	ScheduleDeadline time.Duration
+	// InitContainers and Sidecars make the task a group of containers
+	// placed together on one worker; see Container.
+	InitContainers []Container
+	Sidecars       []Container
+
+	// Secrets are resolved by the manager when the task is dispatched; the
+	// values travel only in the start event, never in the task itself.
+	Secrets []SecretRef
+	// Templates are config files rendered by the worker; see Template.
+	Templates []Template
+
+	// StopSignal is sent to the container to stop it, SIGTERM if empty.
+	// StopTimeout is how long it then has to exit before it is killed;
+	// zero leaves Docker's default of 10s.
+	StopSignal  string
+	StopTimeout time.Duration
+
+	// Reason says why the task is in its current state, if that was not
+	// its own doing, such as "deadline exceeded".
+	Reason string
+	// Status is how the task's container ended, once it has.
+	Status Status
 }
 
+// Reasons a task is failed by mongeta rather than by its own exit.
+const (
+	ReasonDeadlineExceeded         = "deadline exceeded"
+	ReasonScheduleDeadlineExceeded = "schedule deadline exceeded"
+	ReasonWorkerLost               = "worker unreachable"
+	ReasonQueueFull                = "manager queue full"
+)
+
 type TaskEvent struct {
 	ID        uuid.UUID
 	State     State
 	TimeStamp time.Time
 	Task      Task
+	// Secrets holds the values of the secrets the task and its templates
+	// read, and KV the values of the keys its templates read, by name. They
+	// are set on start events sent to a worker only.
+	Secrets map[string]string `json:",omitempty"`
+	KV      map[string]string `json:",omitempty"`
 }
 
 type Config struct {
@@ -66,6 +146,16 @@ type Config struct {
 	Disk          int64
 	Env           []string
 	RestartPolicy container.RestartPolicyMode
+	StopSignal    string
+	StopTimeout   time.Duration
+	// NetworkMode joins another container's network namespace when set to
+	// "container:<id>". Such a container cannot publish ports of its own.
+	NetworkMode string
+	// Binds are host paths mounted into the container, as "src:dst:ro".
+	Binds []string
+	// PortBindings publishes exposed ports on fixed host ports. Without
+	// them every exposed port is published on one Docker picks.
+	PortBindings nat.PortMap
 }
 
 type Docker struct {
@@ -94,7 +184,36 @@ func NewConfig(t *Task) *Config {
 		Memory:        t.Memory,
 		Disk:          t.Disk,
 		RestartPolicy: t.RestartPolicy,
+		StopSignal:    t.StopSignal,
+		StopTimeout:   t.StopTimeout,
+		PortBindings:  portMap(t.PortBindings),
+	}
+}
+
+func portMap(bindings map[string]string) nat.PortMap {
+	if len(bindings) == 0 {
+		return nil
+	}
+	pm := make(nat.PortMap, len(bindings))
+	for port, host := range bindings {
+		pm[nat.Port(port)] = []nat.PortBinding{{HostPort: host}}
 	}
+	return pm
+}
+
+// Validate checks the parts of a task spec beyond its image: its labels,
+// its task group, its secret references and its templates.
+func Validate(t Task) error {
+	if err := ValidateLabels(t); err != nil {
+		return err
+	}
+	if err := ValidateGroup(t); err != nil {
+		return err
+	}
+	if err := ValidateSecrets(t); err != nil {
+		return err
+	}
+	return ValidateTemplates(t)
 }
 
 func NewDocker(c *Config) (*Docker, error) {
@@ -132,6 +251,8 @@ func (d *Docker) Run() DockerResult {
 
 	cc := container.Config{
 		Image:        d.Config.Image,
+		Cmd:          d.Config.Cmd,
+		StopSignal:   d.Config.StopSignal,
 		Tty:          false,
 		Env:          d.Config.Env,
 		ExposedPorts: d.Config.ExposedPorts,
@@ -143,7 +264,10 @@ func (d *Docker) Run() DockerResult {
 	hc := container.HostConfig{
 		RestartPolicy:   rp,
 		Resources:       r,
-		PublishAllPorts: true,
+		NetworkMode:     container.NetworkMode(d.Config.NetworkMode),
+		Binds:           d.Config.Binds,
+		PortBindings:    d.Config.PortBindings,
+		PublishAllPorts: d.Config.NetworkMode == "" && len(d.Config.PortBindings) == 0,
 	}
 	resp, err := d.Client.ContainerCreate(ctx, &cc, &hc, nil, nil, d.Config.Name)
 	if err != nil {
@@ -170,10 +294,17 @@ func (d *Docker) Run() DockerResult {
 	return DockerResult{ContainerID: resp.ID, Action: "Start", Result: "Success"}
 }
 
+// Stop sends the container its stop signal, waits up to the stop timeout for
+// it to exit, kills it if it has not, and removes it.
 func (d *Docker) Stop(ID string) DockerResult {
-	logger.Info("stopping container", "container_id", ID)
+	logger.Info("stopping container", "container_id", ID, "signal", d.Config.StopSignal, "timeout", d.Config.StopTimeout)
 	ctx := context.Background()
-	err := d.Client.ContainerStop(ctx, ID, container.StopOptions{})
+	opts := container.StopOptions{Signal: d.Config.StopSignal}
+	if d.Config.StopTimeout > 0 {
+		secs := int(math.Ceil(d.Config.StopTimeout.Seconds()))
+		opts.Timeout = &secs
+	}
+	err := d.Client.ContainerStop(ctx, ID, opts)
 	if err != nil {
 		logger.Error("error stopping container", "container_id", ID, "err", err)
 		return DockerResult{Error: err, Action: "Stop", Result: ID}
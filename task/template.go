@@ -0,0 +1,275 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/google/uuid"
+	"github.com/moby/moby/api/types/container"
+)
+
+// Template is a config file rendered by the worker with text/template and
+// mounted read-only into the task's container at Destination. Besides the
+// fields of TemplateData, Data may call
+//
+//	{{ secret "name" }}             a secret from the manager's store
+//	{{ key "name" }}                a value from the manager's KV store
+//	{{ keyOrDefault "name" "def" }} the same, or def if it is not set
+//
+// with a literal name, so the manager knows which values to send. When a
+// secret or key the template reads changes, the file is rendered again and
+// ChangeMode decides what happens to the running container.
+type Template struct {
+	Data        string
+	Destination string
+	ChangeMode  ChangeMode
+	// ChangeSignal is sent to the container with ChangeModeSignal.
+	ChangeSignal string
+}
+
+type ChangeMode string
+
+const (
+	// ChangeModeNoop only rewrites the file, which is also the default.
+	ChangeModeNoop    ChangeMode = "noop"
+	ChangeModeRestart ChangeMode = "restart"
+	ChangeModeSignal  ChangeMode = "signal"
+)
+
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// TemplateData is what a template is executed with.
+type TemplateData struct {
+	Task TemplateTask
+	// Ports maps each exposed port, such as "80/tcp", to its host port, as
+	// allocated by the worker. Ports Docker picks itself are only known
+	// once the container has started; the file is then rewritten without
+	// applying ChangeMode.
+	Ports map[string]string
+	Node  TemplateNode
+}
+
+type TemplateTask struct {
+	ID    uuid.UUID
+	Name  string
+	JobID uuid.UUID
+}
+
+// TemplateNode is the worker the task runs on.
+type TemplateNode struct {
+	Name   string
+	Labels map[string]string
+}
+
+// NewTemplateData returns the data to render the templates of t with on
+// the node named node.
+func NewTemplateData(t *Task, node string, labels map[string]string) TemplateData {
+	data := TemplateData{
+		Task:  TemplateTask{ID: t.ID, Name: t.Name, JobID: t.JobID},
+		Ports: make(map[string]string),
+		Node:  TemplateNode{Name: node, Labels: labels},
+	}
+	maps.Copy(data.Ports, t.PortBindings)
+	for port, bindings := range t.HostPorts {
+		if len(bindings) > 0 {
+			data.Ports[string(port)] = bindings[0].HostPort
+		}
+	}
+	return data
+}
+
+// lookupFuncs are the functions that read values sent by the manager. The
+// stubs here are only used to parse; Render binds the real values.
+var lookupFuncs = template.FuncMap{
+	"secret":       func(string) (string, error) { return "", nil },
+	"key":          func(string) (string, error) { return "", nil },
+	"keyOrDefault": func(string, string) string { return "" },
+}
+
+func parseTemplate(data string, funcs template.FuncMap) (*template.Template, error) {
+	return template.New("template").Option("missingkey=error").Funcs(funcs).Parse(data)
+}
+
+// ValidateTemplates checks the templates of t: each must parse, have a
+// clean absolute destination of its own and a known change mode.
+func ValidateTemplates(t Task) error {
+	seen := make(map[string]bool)
+	for i, tpl := range t.Templates {
+		if !path.IsAbs(tpl.Destination) || path.Clean(tpl.Destination) != tpl.Destination {
+			return fmt.Errorf("%w: template %d destination %q must be a clean absolute path", ErrInvalidTemplate, i, tpl.Destination)
+		}
+		if seen[tpl.Destination] {
+			return fmt.Errorf("%w: duplicate destination %q", ErrInvalidTemplate, tpl.Destination)
+		}
+		seen[tpl.Destination] = true
+		switch tpl.ChangeMode {
+		case "", ChangeModeNoop, ChangeModeRestart:
+		case ChangeModeSignal:
+			if strings.TrimSpace(tpl.ChangeSignal) == "" {
+				return fmt.Errorf("%w: template %q has change mode signal but no signal", ErrInvalidTemplate, tpl.Destination)
+			}
+		default:
+			return fmt.Errorf("%w: template %q has unknown change mode %q", ErrInvalidTemplate, tpl.Destination, tpl.ChangeMode)
+		}
+		if _, _, err := templateRefs(tpl); err != nil {
+			return fmt.Errorf("%w: template %q: %v", ErrInvalidTemplate, tpl.Destination, err)
+		}
+	}
+	return nil
+}
+
+// TemplateRefs returns the names of the secrets and KV keys the templates
+// of t read, sorted and without duplicates.
+func TemplateRefs(t Task) (secrets, keys []string, err error) {
+	for _, tpl := range t.Templates {
+		s, k, err := templateRefs(tpl)
+		if err != nil {
+			return nil, nil, err
+		}
+		secrets = append(secrets, s...)
+		keys = append(keys, k...)
+	}
+	slices.Sort(secrets)
+	slices.Sort(keys)
+	return slices.Compact(secrets), slices.Compact(keys), nil
+}
+
+func templateRefs(tpl Template) (secrets, keys []string, err error) {
+	tmpl, err := parseTemplate(tpl.Data, lookupFuncs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var walk func(n parse.Node) error
+	walk = func(n parse.Node) error {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return nil
+			}
+			for _, c := range n.Nodes {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		case *parse.ActionNode:
+			return walk(n.Pipe)
+		case *parse.IfNode:
+			return walkBranch(&n.BranchNode, walk)
+		case *parse.RangeNode:
+			return walkBranch(&n.BranchNode, walk)
+		case *parse.WithNode:
+			return walkBranch(&n.BranchNode, walk)
+		case *parse.TemplateNode:
+			if n.Pipe != nil {
+				return walk(n.Pipe)
+			}
+		case *parse.PipeNode:
+			if n == nil {
+				return nil
+			}
+			for _, c := range n.Cmds {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		case *parse.CommandNode:
+			if id, ok := n.Args[0].(*parse.IdentifierNode); ok {
+				if _, lookup := lookupFuncs[id.Ident]; lookup {
+					if len(n.Args) < 2 {
+						return fmt.Errorf("%s needs a name", id.Ident)
+					}
+					name, ok := n.Args[1].(*parse.StringNode)
+					if !ok {
+						return fmt.Errorf("%s must be called with a literal name", id.Ident)
+					}
+					if id.Ident == "secret" {
+						secrets = append(secrets, name.Text)
+					} else {
+						keys = append(keys, name.Text)
+					}
+				}
+			}
+			for _, a := range n.Args {
+				if err := walk(a); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, t := range tmpl.Templates() {
+		if err := walk(t.Tree.Root); err != nil {
+			return nil, nil, err
+		}
+	}
+	return secrets, keys, nil
+}
+
+func walkBranch(b *parse.BranchNode, walk func(parse.Node) error) error {
+	for _, n := range []parse.Node{b.Pipe, b.List, b.ElseList} {
+		if err := walk(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render executes tpl with data, reading secrets and keys from the values
+// sent by the manager.
+func Render(tpl Template, data TemplateData, secrets, kv map[string]string) ([]byte, error) {
+	tmpl, err := parseTemplate(tpl.Data, template.FuncMap{
+		"secret": func(name string) (string, error) {
+			v, ok := secrets[name]
+			if !ok {
+				return "", fmt.Errorf("secret %q was not sent with the task", name)
+			}
+			return v, nil
+		},
+		"key": func(name string) (string, error) {
+			v, ok := kv[name]
+			if !ok {
+				return "", fmt.Errorf("key %q is not set", name)
+			}
+			return v, nil
+		},
+		"keyOrDefault": func(name, def string) string {
+			if v, ok := kv[name]; ok {
+				return v
+			}
+			return def
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restart restarts a running container, giving it its stop signal and
+// timeout first.
+func (d *Docker) Restart(containerID string) error {
+	opts := container.StopOptions{Signal: d.Config.StopSignal}
+	if d.Config.StopTimeout > 0 {
+		secs := int(math.Ceil(d.Config.StopTimeout.Seconds()))
+		opts.Timeout = &secs
+	}
+	return d.Client.ContainerRestart(context.Background(), containerID, opts)
+}
+
+// Signal sends sig to a running container.
+func (d *Docker) Signal(containerID, sig string) error {
+	return d.Client.ContainerKill(context.Background(), containerID, sig)
+}
@@ -0,0 +1,47 @@
+package task
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestTemplateRefsAndRender(t *testing.T) {
+	tpl := Template{
+		Destination: "/etc/app.conf",
+		Data: `name={{ .Task.Name }} zone={{ index .Node.Labels "zone" }} port={{ index .Ports "80/tcp" }}
+{{ if key "app/debug" }}password={{ secret "db-password" }}{{ end }}
+level={{ keyOrDefault "app/level" "info" }}`,
+	}
+	tk := Task{ID: uuid.New(), Name: "web", Templates: []Template{tpl}}
+
+	secrets, keys, err := TemplateRefs(tk)
+	if err != nil {
+		t.Fatalf("TemplateRefs: unexpected error: %v", err)
+	}
+	if !slices.Equal(secrets, []string{"db-password"}) || !slices.Equal(keys, []string{"app/debug", "app/level"}) {
+		t.Fatalf("TemplateRefs = %v, %v", secrets, keys)
+	}
+
+	tk.HostPorts = nat.PortMap{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}}}
+	data := NewTemplateData(&tk, "w1", map[string]string{"zone": "eu-1"})
+	out, err := Render(tpl, data, map[string]string{"db-password": "hunter2"}, map[string]string{"app/debug": "true"})
+	if err != nil {
+		t.Fatalf("Render: unexpected error: %v", err)
+	}
+	want := "name=web zone=eu-1 port=32768\npassword=hunter2\nlevel=info"
+	if string(out) != want {
+		t.Errorf("Render = %q, want %q", out, want)
+	}
+
+	if _, err := Render(tpl, data, nil, nil); err == nil {
+		t.Error("Render with a missing key: expected an error")
+	}
+
+	bad := Task{Templates: []Template{{Destination: "/x", Data: `{{ secret .Name }}`}}}
+	if err := ValidateTemplates(bad); err == nil {
+		t.Error("ValidateTemplates with a non-literal secret name: expected an error")
+	}
+}
@@ -53,10 +53,13 @@ func (a *API) initRouter() {
 		r.Post("/", a.StartTaskHandler)
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
+			r.Get("/", a.GetTaskHandler)
 			r.Delete("/", a.StopTaskHandler)
+			r.Put("/templates", a.UpdateTemplatesHandler)
 		})
 	})
 	a.Router.Route("/stats", func(r chi.Router) {
 		r.Get("/", a.GetStatsHandler)
 	})
+	a.Router.Get("/metrics", a.MetricsHandler)
 }
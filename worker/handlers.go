@@ -1,9 +1,12 @@
 package worker
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/ctfrancia/mongeta/logger"
 	"github.com/ctfrancia/mongeta/task"
@@ -28,15 +31,60 @@ func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.Worker.AddTask(te.Task)
+	// The inputs must be in place before the task can be taken off the
+	// queue.
+	prev := a.Worker.inputsOf(te.Task.ID)
+	a.Worker.SetInputs(te.Task.ID, te.Secrets, te.KV)
+	if err := a.Worker.AddTask(te.Task); err != nil {
+		a.Worker.resetInputs(te.Task.ID, prev)
+		a.writeQueueFull(w)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(te.Task)
 }
 
+// GetTasksHandler lists tasks, filtered by the state, name, image and
+// selector query parameters and ordered by sort. With a limit, the cursor of the next page
+// is sent in the X-Next-Cursor header.
 func (a *API) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q, err := task.ParseQuery(r.URL.Query())
+	var tasks []task.Task
+	var next string
+	if err == nil {
+		tasks, next, err = a.Worker.QueryTasks(q)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        err.Error(),
+		})
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tasks)
+}
+
+func (a *API) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		logger.Warn("invalid taskID", "task_id", chi.URLParam(r, "taskID"), "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	t, ok := a.Worker.GetTask(tID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(a.Worker.GetTasks())
+	json.NewEncoder(w).Encode(t)
 }
 
 func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -63,7 +111,10 @@ func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 
 	taskCopy := *taskToStop
 	taskCopy.State = task.Completed
-	a.Worker.AddTask(taskCopy)
+	if err := a.Worker.AddTask(taskCopy); err != nil {
+		a.writeQueueFull(w)
+		return
+	}
 
 	logger.Info("stopping container", "task_id", taskToStop.ID, "container_id", taskToStop.ContainerID)
 	w.WriteHeader(http.StatusNoContent)
@@ -74,3 +125,51 @@ func (a *API) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(a.Worker.Stats)
 }
+
+// UpdateTemplatesHandler takes new secret and KV values for a running
+// task's templates from the manager.
+func (a *API) UpdateTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		logger.Warn("invalid taskID", "task_id", chi.URLParam(r, "taskID"), "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	te := task.TaskEvent{}
+	if err := json.NewDecoder(r.Body).Decode(&te); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			HTTPStatusCode: http.StatusBadRequest,
+			Message:        fmt.Sprintf("Error unmarshalling task event: %v", err),
+		})
+		return
+	}
+
+	err = a.Worker.RefreshTemplates(tID, te.Secrets, te.KV)
+	switch {
+	case errors.Is(err, ErrTaskNotRunning):
+		w.WriteHeader(http.StatusNotFound)
+	case err != nil:
+		logger.Error("error refreshing templates", "task_id", tID, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			HTTPStatusCode: http.StatusInternalServerError,
+			Message:        err.Error(),
+		})
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeQueueFull tells the manager to send the task again after
+// Worker.RetryAfter.
+func (a *API) writeQueueFull(w http.ResponseWriter) {
+	retry := cmp.Or(a.Worker.RetryAfter, DefaultRetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(retry.Seconds(), 1))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		HTTPStatusCode: http.StatusTooManyRequests,
+		Message:        ErrQueueFull.Error(),
+	})
+}
@@ -0,0 +1,18 @@
+package worker
+
+import (
+	"net/http"
+
+	"github.com/ctfrancia/mongeta/metrics"
+)
+
+// MetricsHandler reports the depth and capacity of the worker's queue and
+// how many tasks it rejected, in the Prometheus text format.
+func (a *API) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	wk := a.Worker
+	metrics.Write(w, []metrics.Metric{
+		{Name: "mongeta_worker_queue_depth", Kind: metrics.Gauge, Help: "Tasks waiting in the queue.", Value: uint64(len(wk.Queue))},
+		{Name: "mongeta_worker_queue_capacity", Kind: metrics.Gauge, Help: "Size of the queue.", Value: uint64(cap(wk.Queue))},
+		{Name: "mongeta_worker_queue_rejected_total", Kind: metrics.Counter, Help: "Tasks rejected because the queue was full.", Value: wk.rejected.Load()},
+	})
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+// Default range host ports are allocated from.
+const (
+	DefaultMinPort = 20000
+	DefaultMaxPort = 32000
+)
+
+// allocatePorts picks a host port in the worker's range for every exposed
+// port of t and records them in t.PortBindings. The search for each port
+// starts at an offset hashed from the task ID, so a task restarted on the
+// same worker gets the same ports back if they are free.
+func (w *Worker) allocatePorts(t *task.Task) error {
+	if len(t.ExposedPorts) == 0 {
+		return nil
+	}
+	size := w.MaxPort - w.MinPort + 1
+	if size <= 0 {
+		return fmt.Errorf("invalid port range %d-%d", w.MinPort, w.MaxPort)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// A restarted task starts over, most likely getting its ports back.
+	w.releasePortsLocked(t.ID)
+	bindings := make(map[string]string, len(t.ExposedPorts))
+	for _, port := range slices.Sorted(maps.Keys(t.ExposedPorts)) {
+		h := fnv.New32a()
+		h.Write(t.ID[:])
+		h.Write([]byte(port))
+		start := int(h.Sum32() % uint32(size))
+
+		host := 0
+		for i := range size {
+			p := w.MinPort + (start+i)%size
+			if _, taken := w.ports[p]; taken || !portFree(port.Proto(), p) {
+				continue
+			}
+			host = p
+			break
+		}
+		if host == 0 {
+			w.releasePortsLocked(t.ID)
+			return fmt.Errorf("no free host port for %s in %d-%d", port, w.MinPort, w.MaxPort)
+		}
+		w.ports[host] = t.ID
+		bindings[string(port)] = strconv.Itoa(host)
+	}
+	t.PortBindings = bindings
+	return nil
+}
+
+// releasePortsLocked frees the host ports of a task. The caller must hold
+// w.mu.
+func (w *Worker) releasePortsLocked(id uuid.UUID) {
+	for p, owner := range w.ports {
+		if owner == id {
+			delete(w.ports, p)
+		}
+	}
+}
+
+// portFree reports whether nothing else on the host is listening on port.
+func portFree(proto string, port int) bool {
+	addr := ":" + strconv.Itoa(port)
+	if proto == "udp" {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"maps"
+	"strconv"
+	"testing"
+
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		ports    nat.PortSet
+		wantErr  bool
+	}{
+		{"no ports", 40000, 40099, nil, false},
+		{"one port", 40000, 40099, nat.PortSet{"80/tcp": {}}, false},
+		{"tcp and udp", 40000, 40099, nat.PortSet{"80/tcp": {}, "53/udp": {}}, false},
+		{"range too small", 40100, 40100, nat.PortSet{"80/tcp": {}, "443/tcp": {}}, true},
+		{"empty range", 40200, 40199, nat.PortSet{"80/tcp": {}}, true},
+	}
+	for _, tt := range tests {
+		w := NewWorker(1)
+		w.MinPort, w.MaxPort = tt.min, tt.max
+		tk := task.Task{ID: uuid.New(), ExposedPorts: tt.ports}
+		err := w.allocatePorts(&tk)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil {
+			if len(w.ports) != 0 {
+				t.Errorf("%s: %d ports still held after a failed allocation", tt.name, len(w.ports))
+			}
+			continue
+		}
+
+		if len(tk.PortBindings) != len(tt.ports) {
+			t.Fatalf("%s: bindings = %v, want one per exposed port", tt.name, tk.PortBindings)
+		}
+		hosts := make(map[string]bool)
+		for port, host := range tk.PortBindings {
+			p, err := strconv.Atoi(host)
+			if err != nil || p < tt.min || p > tt.max {
+				t.Errorf("%s: %s bound to %q, want a port in %d-%d", tt.name, port, host, tt.min, tt.max)
+			}
+			if hosts[host] {
+				t.Errorf("%s: host port %s bound twice", tt.name, host)
+			}
+			hosts[host] = true
+		}
+
+		// Allocating again, as a restart does, gives the same ports back.
+		first := maps.Clone(tk.PortBindings)
+		if err := w.allocatePorts(&tk); err != nil {
+			t.Fatalf("%s: reallocating: unexpected error: %v", tt.name, err)
+		}
+		if !maps.Equal(first, tk.PortBindings) {
+			t.Errorf("%s: reallocated bindings = %v, want %v", tt.name, tk.PortBindings, first)
+		}
+	}
+}
+
+func TestAllocatePortsTaken(t *testing.T) {
+	w := NewWorker(1)
+	w.MinPort, w.MaxPort = 40300, 40300
+	a := task.Task{ID: uuid.New(), ExposedPorts: nat.PortSet{"80/tcp": {}}}
+	b := task.Task{ID: uuid.New(), ExposedPorts: nat.PortSet{"80/tcp": {}}}
+	if err := w.allocatePorts(&a); err != nil {
+		t.Fatalf("first task: unexpected error: %v", err)
+	}
+	if err := w.allocatePorts(&b); err == nil {
+		t.Fatalf("second task got %v from a range the first task holds", b.PortBindings)
+	}
+
+	w.cleanupTask(a.ID)
+	if err := w.allocatePorts(&b); err != nil {
+		t.Fatalf("second task after the first was cleaned up: unexpected error: %v", err)
+	}
+	if b.PortBindings["80/tcp"] != "40300" {
+		t.Errorf("second task bindings = %v, want 80/tcp on 40300", b.PortBindings)
+	}
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ctfrancia/mongeta/task"
+)
+
+// injectSecrets adds the secrets t references to config: as environment
+// variables, or as read-only files mounted from the task's directory.
+func (w *Worker) injectSecrets(t *task.Task, config *task.Config, values map[string]string) error {
+	for i, ref := range t.Secrets {
+		v, ok := values[ref.Name]
+		if !ok {
+			return fmt.Errorf("secret %q was not sent with the task", ref.Name)
+		}
+		if ref.Env != "" {
+			config.Env = append(config.Env, ref.Env+"="+v)
+			continue
+		}
+
+		dir, err := w.makeTaskDir(t.ID)
+		if err != nil {
+			return err
+		}
+		host := filepath.Join(dir, fmt.Sprintf("secret-%d", i))
+		if err := os.WriteFile(host, []byte(v), 0o444); err != nil {
+			return fmt.Errorf("error writing secret %q: %w", ref.Name, err)
+		}
+		config.Binds = append(config.Binds, host+":"+ref.File+":ro")
+	}
+	return nil
+}
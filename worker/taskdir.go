@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/google/uuid"
+)
+
+// taskInputs are the values a task's start event carried: its secrets and
+// the KV values its templates read.
+type taskInputs struct {
+	Secrets map[string]string
+	KV      map[string]string
+}
+
+// SetInputs holds the secret and KV values sent with a task. They are never
+// stored on the task itself, so they do not show up in GET /tasks.
+func (w *Worker) SetInputs(id uuid.UUID, secrets, kv map[string]string) {
+	if len(secrets) == 0 && len(kv) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inputs[id] = taskInputs{Secrets: secrets, KV: kv}
+}
+
+func (w *Worker) inputsOf(id uuid.UUID) taskInputs {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.inputs[id]
+}
+
+// resetInputs puts back the inputs a task had before a start event that
+// was rejected. A task that had none is forgotten.
+func (w *Worker) resetInputs(id uuid.UUID, in taskInputs) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(in.Secrets) == 0 && len(in.KV) == 0 {
+		delete(w.inputs, id)
+		return
+	}
+	w.inputs[id] = in
+}
+
+// cleanupTask deletes the files of a task that is no longer running, and
+// forgets its inputs and host ports.
+func (w *Worker) cleanupTask(id uuid.UUID) {
+	w.mu.Lock()
+	delete(w.inputs, id)
+	w.releasePortsLocked(id)
+	w.mu.Unlock()
+	if w.TaskDir != "" {
+		os.RemoveAll(w.taskDir(id))
+	}
+}
+
+// tmpfsMagic is the filesystem type statfs(2) reports for a tmpfs.
+const tmpfsMagic = 0x01021994
+
+// CheckTaskDir creates TaskDir and makes sure it is on a tmpfs, so secret
+// files and rendered templates never reach a disk. The worker should not
+// start if it fails.
+func (w *Worker) CheckTaskDir() error {
+	if w.TaskDir == "" {
+		return errors.New("no task directory configured")
+	}
+	if err := os.MkdirAll(w.TaskDir, 0o700); err != nil {
+		return fmt.Errorf("error creating task directory: %w", err)
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(w.TaskDir, &fs); err != nil {
+		return fmt.Errorf("error checking task directory: %w", err)
+	}
+	if int64(fs.Type) != tmpfsMagic {
+		return fmt.Errorf("task directory %s is not on a tmpfs", w.TaskDir)
+	}
+	return nil
+}
+
+func (w *Worker) taskDir(id uuid.UUID) string {
+	return filepath.Join(w.TaskDir, id.String())
+}
+
+// makeTaskDir creates the directory of a task. Without a TaskDir, nothing
+// is written rather than falling back to a directory on disk.
+func (w *Worker) makeTaskDir(id uuid.UUID) (string, error) {
+	if w.TaskDir == "" {
+		return "", errors.New("no task directory configured")
+	}
+	dir := w.taskDir(id)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("error creating task directory: %w", err)
+	}
+	return dir, nil
+}
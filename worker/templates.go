@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ctfrancia/mongeta/logger"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+)
+
+var ErrTaskNotRunning = errors.New("task is not running")
+
+// renderTemplates renders the templates of t into the task's directory and
+// mounts each read-only at its destination.
+func (w *Worker) renderTemplates(t *task.Task, config *task.Config, in taskInputs) error {
+	if len(t.Templates) == 0 {
+		return nil
+	}
+	dir, err := w.makeTaskDir(t.ID)
+	if err != nil {
+		return err
+	}
+	data := task.NewTemplateData(t, w.Name, w.Labels)
+	for i, tpl := range t.Templates {
+		out, err := task.Render(tpl, data, in.Secrets, in.KV)
+		if err != nil {
+			return fmt.Errorf("error rendering template %q: %w", tpl.Destination, err)
+		}
+		host := templatePath(dir, i)
+		if err := os.WriteFile(host, out, 0o644); err != nil {
+			return fmt.Errorf("error writing template %q: %w", tpl.Destination, err)
+		}
+		config.Binds = append(config.Binds, host+":"+tpl.Destination+":ro")
+	}
+	return nil
+}
+
+func templatePath(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("template-%d", i))
+}
+
+// RefreshTemplates renders the templates of a running task again with new
+// secret and KV values, applying the change mode of those whose output
+// changed.
+func (w *Worker) RefreshTemplates(id uuid.UUID, secrets, kv map[string]string) error {
+	w.mu.Lock()
+	t, ok := w.DB[id]
+	if !ok || t.State != task.Running {
+		w.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrTaskNotRunning, id)
+	}
+	w.inputs[id] = taskInputs{Secrets: secrets, KV: kv}
+	w.mu.Unlock()
+	return w.rerenderTemplates(id, true)
+}
+
+// rerenderTemplates rewrites the templates of a running task whose output
+// has changed. The files are written in place so the bind mounts see them.
+// With notify, the container is then restarted or signalled as the changed
+// templates ask.
+func (w *Worker) rerenderTemplates(id uuid.UUID, notify bool) error {
+	w.mu.RLock()
+	stored, ok := w.DB[id]
+	if !ok || stored.State != task.Running {
+		w.mu.RUnlock()
+		return fmt.Errorf("%w: %s", ErrTaskNotRunning, id)
+	}
+	t := *stored
+	in := w.inputs[id]
+	w.mu.RUnlock()
+
+	dir := w.taskDir(id)
+	data := task.NewTemplateData(&t, w.Name, w.Labels)
+	restart := false
+	var signals []string
+	for i, tpl := range t.Templates {
+		out, err := task.Render(tpl, data, in.Secrets, in.KV)
+		if err != nil {
+			return fmt.Errorf("error rendering template %q: %w", tpl.Destination, err)
+		}
+		host := templatePath(dir, i)
+		if old, err := os.ReadFile(host); err == nil && bytes.Equal(old, out) {
+			continue
+		}
+		if err := os.WriteFile(host, out, 0o644); err != nil {
+			return fmt.Errorf("error writing template %q: %w", tpl.Destination, err)
+		}
+		logger.Info("template rendered", "task_id", id, "destination", tpl.Destination)
+		switch tpl.ChangeMode {
+		case task.ChangeModeRestart:
+			restart = true
+		case task.ChangeModeSignal:
+			if !slices.Contains(signals, tpl.ChangeSignal) {
+				signals = append(signals, tpl.ChangeSignal)
+			}
+		}
+	}
+	if !notify || (!restart && len(signals) == 0) {
+		return nil
+	}
+
+	d, err := task.NewDocker(task.NewConfig(&t))
+	if err != nil {
+		return err
+	}
+	if restart {
+		logger.Info("restarting container for changed templates", "task_id", id, "container_id", t.ContainerID)
+		return d.Restart(t.ContainerID)
+	}
+	for _, sig := range signals {
+		logger.Info("signalling container for changed templates", "task_id", id, "container_id", t.ContainerID, "signal", sig)
+		if err := d.Signal(t.ContainerID, sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,9 +9,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/ctfrancia/mongeta/logger"
 	"github.com/ctfrancia/mongeta/task"
 	"github.com/google/uuid"
@@ -24,12 +28,44 @@ type Worker struct {
 	mu        sync.RWMutex
 	Stats     *Stats
 	TaskCount int
+	// deadlines holds the MaxRunDuration timer of each running task.
+	deadlines map[uuid.UUID]*time.Timer
+	// TaskDir holds a directory per task for the secret files and rendered
+	// templates mounted into its container. It must be on a tmpfs; see
+	// CheckTaskDir.
+	TaskDir string
+	// Labels describe the node to task templates.
+	Labels map[string]string
+	// inputs holds the secret and KV values sent with each task, kept for
+	// as long as the task runs so its templates can be rendered again.
+	inputs map[uuid.UUID]taskInputs
+	// MinPort and MaxPort bound the host ports allocated to tasks.
+	MinPort int
+	MaxPort int
+	// ports holds the task each allocated host port belongs to.
+	ports map[int]uuid.UUID
+	// RetryAfter is how long the manager is told to wait when Queue is
+	// full, DefaultRetryAfter if zero; rejected counts the tasks turned
+	// away.
+	RetryAfter time.Duration
+	rejected   atomic.Uint64
 }
 
+var ErrQueueFull = errors.New("worker queue full")
+
+// DefaultRetryAfter is how long the manager is told to wait before
+// resending a task the worker had no room for.
+const DefaultRetryAfter = 10 * time.Second
+
 func NewWorker(queueSize int) *Worker {
 	return &Worker{
-		Queue: make(chan task.Task, queueSize),
-		DB:    make(map[uuid.UUID]*task.Task),
+		Queue:     make(chan task.Task, queueSize),
+		DB:        make(map[uuid.UUID]*task.Task),
+		deadlines: make(map[uuid.UUID]*time.Timer),
+		inputs:    make(map[uuid.UUID]taskInputs),
+		MinPort:   DefaultMinPort,
+		MaxPort:   DefaultMaxPort,
+		ports:     make(map[int]uuid.UUID),
 	}
 }
 
@@ -43,6 +79,18 @@ func (w *Worker) GetTasks() []*task.Task {
 	return tasks
 }
 
+// QueryTasks returns copies of the tasks q selects and the cursor of the
+// next page.
+func (w *Worker) QueryTasks(q task.Query) ([]task.Task, string, error) {
+	w.mu.RLock()
+	tasks := make([]task.Task, 0, len(w.DB))
+	for _, t := range w.DB {
+		tasks = append(tasks, *t)
+	}
+	w.mu.RUnlock()
+	return q.Apply(tasks)
+}
+
 // GetTask returns the task with the given ID and whether it was found,
 // reading w.DB under a read lock.
 func (w *Worker) GetTask(id uuid.UUID) (*task.Task, bool) {
@@ -70,34 +118,245 @@ func (w *Worker) CollectStats(ctx context.Context, interval time.Duration) {
 
 func (w *Worker) StartTask(t task.Task) task.DockerResult {
 	t.StartTime = time.Now().UTC()
+	t.Reason = ""
+	t.Status = task.Status{}
+	in := w.inputsOf(t.ID)
+
+	if err := w.allocatePorts(&t); err != nil {
+		logger.Error("error allocating ports", "task_id", t.ID, "err", err)
+		w.cleanupTask(t.ID)
+		t.State = task.Failed
+		t.Reason = err.Error()
+		t.FinishTime = time.Now().UTC()
+		w.mu.Lock()
+		w.DB[t.ID] = &t
+		w.mu.Unlock()
+		return task.DockerResult{Error: err}
+	}
+
+	if err := w.runInitContainers(&t); err != nil {
+		logger.Error("init container failed", "task_id", t.ID, "err", err)
+		w.cleanupTask(t.ID)
+		t.State = task.Failed
+		t.Reason = err.Error()
+		t.FinishTime = time.Now().UTC()
+		w.mu.Lock()
+		w.DB[t.ID] = &t
+		w.mu.Unlock()
+		return task.DockerResult{Error: err}
+	}
 
 	config := task.NewConfig(&t)
+	config.Env = append(config.Env, task.RuntimeEnv(&t, w.Name)...)
+	err := w.injectSecrets(&t, config, in.Secrets)
+	if err == nil {
+		err = w.renderTemplates(&t, config, in)
+	}
+	if err != nil {
+		logger.Error("error preparing task files", "task_id", t.ID, "err", err)
+		w.cleanupTask(t.ID)
+		t.State = task.Failed
+		t.Reason = err.Error()
+		t.FinishTime = time.Now().UTC()
+		w.mu.Lock()
+		w.DB[t.ID] = &t
+		w.mu.Unlock()
+		return task.DockerResult{Error: err}
+	}
 	d, err := task.NewDocker(config)
 	if err != nil {
 		logger.Error("error creating docker client", "err", err)
+		w.cleanupTask(t.ID)
 		return task.DockerResult{Error: err}
 	}
 
 	result := d.Run()
 	if result.Error != nil {
 		logger.Error("error starting container", "container_id", t.ContainerID, "err", result.Error)
+		w.cleanupTask(t.ID)
 		t.State = task.Failed
+		t.Reason = result.Error.Error()
+		t.FinishTime = time.Now().UTC()
 		w.mu.Lock()
 		w.DB[t.ID] = &t
 		w.mu.Unlock()
 		return result
 	}
-
 	t.ContainerID = result.ContainerID
+
+	if err := w.startSidecars(&t); err != nil {
+		logger.Error("error starting sidecar", "task_id", t.ID, "err", err)
+		if r := d.Stop(t.ContainerID); r.Error != nil {
+			logger.Error("error stopping container", "container_id", t.ContainerID, "err", r.Error)
+		}
+		w.stopSidecars(t)
+		w.cleanupTask(t.ID)
+		t.State = task.Failed
+		t.Reason = err.Error()
+		t.FinishTime = time.Now().UTC()
+		w.mu.Lock()
+		w.DB[t.ID] = &t
+		w.mu.Unlock()
+		return task.DockerResult{Error: err}
+	}
+
 	t.State = task.Running
 	w.mu.Lock()
 	w.DB[t.ID] = &t
+	w.clearDeadlineLocked(t.ID)
+	if t.MaxRunDuration > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(t.MaxRunDuration, func() {
+			w.mu.Lock()
+			// The task may have been stopped, or started again with a
+			// new timer, while this one was firing.
+			current := w.deadlines[t.ID] == timer
+			if current {
+				delete(w.deadlines, t.ID)
+			}
+			w.mu.Unlock()
+			if current {
+				w.killTask(t.ID)
+			}
+		})
+		w.deadlines[t.ID] = timer
+	}
 	w.mu.Unlock()
 
 	return result
 }
 
+// runInitContainers runs the init containers of t one at a time, each to
+// completion, and fails on the first that does not exit 0.
+func (w *Worker) runInitContainers(t *task.Task) error {
+	for _, c := range t.InitContainers {
+		config := task.NewContainerConfig(t, c)
+		config.Env = append(config.Env, task.RuntimeEnv(t, w.Name)...)
+		d, err := task.NewDocker(config)
+		if err != nil {
+			return err
+		}
+		result := d.Run()
+		if result.Error != nil {
+			return fmt.Errorf("init container %q: %w", c.Name, result.Error)
+		}
+		code, err := d.Wait(context.Background(), result.ContainerID)
+		if r := d.Stop(result.ContainerID); r.Error != nil {
+			logger.Warn("error removing init container", "container_id", result.ContainerID, "err", r.Error)
+		}
+		if err != nil {
+			return fmt.Errorf("init container %q: %w", c.Name, err)
+		}
+		if code != 0 {
+			return fmt.Errorf("init container %q exited with code %d", c.Name, code)
+		}
+		logger.Info("init container completed", "task_id", t.ID, "container", c.Name)
+	}
+	return nil
+}
+
+// startSidecars starts the sidecars of t in the network namespace of its
+// main container, recording their container IDs on t.
+func (w *Worker) startSidecars(t *task.Task) error {
+	t.Sidecars = slices.Clone(t.Sidecars)
+	for i := range t.Sidecars {
+		c := &t.Sidecars[i]
+		config := task.NewContainerConfig(t, *c)
+		config.Env = append(config.Env, task.RuntimeEnv(t, w.Name)...)
+		config.NetworkMode = "container:" + t.ContainerID
+		d, err := task.NewDocker(config)
+		if err != nil {
+			return err
+		}
+		result := d.Run()
+		if result.Error != nil {
+			return fmt.Errorf("sidecar %q: %w", c.Name, result.Error)
+		}
+		c.ContainerID = result.ContainerID
+	}
+	return nil
+}
+
+// stopSidecars stops and removes every sidecar of t that was started.
+func (w *Worker) stopSidecars(t task.Task) {
+	for _, c := range t.Sidecars {
+		if c.ContainerID == "" {
+			continue
+		}
+		d, err := task.NewDocker(task.NewContainerConfig(&t, c))
+		if err != nil {
+			logger.Error("error creating docker client", "err", err)
+			continue
+		}
+		if r := d.Stop(c.ContainerID); r.Error != nil {
+			logger.Error("error stopping sidecar", "task_id", t.ID, "container", c.Name, "err", r.Error)
+		}
+	}
+}
+
+// clearDeadlineLocked stops the MaxRunDuration timer of a task that has
+// finished. The caller must hold w.mu.
+func (w *Worker) clearDeadlineLocked(id uuid.UUID) {
+	if timer, ok := w.deadlines[id]; ok {
+		timer.Stop()
+		delete(w.deadlines, id)
+	}
+}
+
+// killTask stops a task that has run past its MaxRunDuration and fails it.
+func (w *Worker) killTask(id uuid.UUID) {
+	w.mu.Lock()
+	t, ok := w.DB[id]
+	if !ok || t.State != task.Running {
+		w.mu.Unlock()
+		return
+	}
+	// Set before the container goes so that an update racing the kill
+	// still reports why the task failed.
+	t.Reason = task.ReasonDeadlineExceeded
+	c := *t
+	w.mu.Unlock()
+
+	logger.Warn("task exceeded its maximum run duration, killing", "task_id", id, "max_run_duration", c.MaxRunDuration)
+	var err error
+	if d, derr := task.NewDocker(task.NewConfig(&c)); derr != nil {
+		err = derr
+	} else {
+		err = d.Stop(c.ContainerID).Error
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok = w.DB[id]
+	if !ok {
+		return
+	}
+	if err != nil {
+		logger.Error("error killing container", "container_id", c.ContainerID, "err", err)
+		t.Reason = ""
+		return
+	}
+	if t.State == task.Running {
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+	}
+	go w.stopSidecars(c)
+	go w.cleanupTask(id)
+}
+
+// StopTask stops the task's container, leaving the task Stopping for as long
+// as the container takes to exit within its StopTimeout.
 func (w *Worker) StopTask(t task.Task) task.DockerResult {
+	w.mu.Lock()
+	if stored, ok := w.DB[t.ID]; ok {
+		if stored.State == task.Running {
+			stored.State = task.Stopping
+		}
+		// Only the worker knows the sidecars' container IDs.
+		t.Sidecars = stored.Sidecars
+	}
+	w.mu.Unlock()
+
 	config := task.NewConfig(&t)
 
 	d, err := task.NewDocker(config)
@@ -109,24 +368,59 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 	result := d.Stop(t.ContainerID)
 	if result.Error != nil {
 		logger.Error("error stopping container", "container_id", t.ContainerID, "err", result.Error)
+		// Hand the task back to updateTasks to find out what is left.
+		w.mu.Lock()
+		if stored, ok := w.DB[t.ID]; ok && stored.State == task.Stopping {
+			stored.State = task.Running
+		}
+		w.mu.Unlock()
 		return result
 	}
 
+	w.stopSidecars(t)
+	w.cleanupTask(t.ID)
+
 	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
 	w.mu.Lock()
 	w.DB[t.ID] = &t
+	w.clearDeadlineLocked(t.ID)
 	w.mu.Unlock()
 	logger.Info("stopped container", "container_id", t.ContainerID, "task_id", t.ID)
 
 	return result
 }
 
-func (w *Worker) AddTask(t task.Task) {
+// restartTask replaces the container of a task the manager is restarting,
+// after a failure or a failed health check, with a fresh one.
+func (w *Worker) restartTask(old, next task.Task) task.DockerResult {
+	logger.Info("restarting task", "task_id", old.ID, "attempt", next.RestartCount)
+	w.mu.Lock()
+	w.clearDeadlineLocked(old.ID)
+	w.mu.Unlock()
+
+	// The old container is stopped if it still runs, and removed either
+	// way so the new one can take its name.
+	if old.ContainerID != "" {
+		if d, err := task.NewDocker(task.NewConfig(&old)); err != nil {
+			logger.Error("error creating docker client", "err", err)
+		} else if r := d.Stop(old.ContainerID); r.Error != nil && !cerrdefs.IsNotFound(r.Error) {
+			logger.Warn("error removing old container", "container_id", old.ContainerID, "err", r.Error)
+		}
+	}
+	w.stopSidecars(old)
+	return w.StartTask(next)
+}
+
+// AddTask queues t to be run or stopped, or returns ErrQueueFull.
+func (w *Worker) AddTask(t task.Task) error {
 	select {
 	case w.Queue <- t:
+		return nil
 	default:
-		logger.Warn("worker queue full, dropping task", "task_id", t.ID)
+		w.rejected.Add(1)
+		logger.Warn("worker queue full, rejecting task", "task_id", t.ID)
+		return ErrQueueFull
 	}
 }
 
@@ -145,12 +439,45 @@ func (w *Worker) runTask() task.DockerResult {
 		}
 
 		var result task.DockerResult
+		if taskPersisted.State == task.Stopping && taskQueued.State == task.Completed {
+			logger.Info("task is already stopping", "task_id", taskQueued.ID)
+			return result
+		}
+		if taskQueued.State == task.Scheduled && task.ValidStateTransition(taskPersisted.State, task.Restarting) {
+			w.mu.Lock()
+			taskPersisted.State = task.Restarting
+			old := *taskPersisted
+			w.mu.Unlock()
+			// Stopping the old container takes its grace period.
+			go func() {
+				if r := w.restartTask(old, taskQueued); r.Error != nil {
+					logger.Error("error restarting task", "task_id", taskQueued.ID, "err", r.Error)
+				}
+			}()
+			return result
+		}
 		if task.ValidStateTransition(taskPersisted.State, taskQueued.State) {
 			switch taskQueued.State {
 			case task.Scheduled:
-				result = w.StartTask(taskQueued)
+				if len(taskQueued.InitContainers) == 0 {
+					result = w.StartTask(taskQueued)
+					break
+				}
+				// Init containers run to completion first, which may
+				// take a while.
+				go func() {
+					if r := w.StartTask(taskQueued); r.Error != nil {
+						logger.Error("error starting task", "task_id", taskQueued.ID, "err", r.Error)
+					}
+				}()
 			case task.Completed:
-				result = w.StopTask(taskQueued)
+				// The grace period may be long, so the stop must not hold
+				// up the rest of the queue.
+				go func() {
+					if r := w.StopTask(taskQueued); r.Error != nil {
+						logger.Error("error stopping task", "task_id", taskQueued.ID, "err", r.Error)
+					}
+				}()
 			default:
 				result.Error = errors.New("we should not get here")
 			}
@@ -194,7 +521,7 @@ func (w *Worker) updateTasks() {
 	w.mu.RLock()
 	ids := make([]uuid.UUID, 0, len(w.DB))
 	for id, t := range w.DB {
-		if t.State == task.Running {
+		if t.State == task.Running || t.State == task.Unknown {
 			ids = append(ids, id)
 		}
 	}
@@ -202,29 +529,87 @@ func (w *Worker) updateTasks() {
 
 	for _, id := range ids {
 		w.mu.RLock()
-		t := w.DB[id]
+		t := *w.DB[id]
 		w.mu.RUnlock()
 
-		resp := w.InspectTask(*t)
+		resp := w.InspectTask(t)
 		if resp.Error != nil {
 			logger.Error("error updating task", "task_id", id, "err", resp.Error)
 		}
+		w.updateTask(id, resp)
+	}
+}
 
-		w.mu.Lock()
-		if resp.Container == nil {
-			logger.Error("no container for running task", "task_id", id)
-			w.DB[id].State = task.Failed
-			w.mu.Unlock()
-			continue
-		}
+// updateTask brings a running task up to date with what inspecting its
+// container found: an exited container completes or fails the task, and
+// one Docker could not inspect leaves it Unknown.
+func (w *Worker) updateTask(id uuid.UUID, resp task.DockerInspectResponse) {
+	w.mu.Lock()
+	if s := w.DB[id].State; s != task.Running && s != task.Unknown {
+		// Stopped or restarted while it was being inspected.
+		w.mu.Unlock()
+		return
+	}
+	if resp.Error != nil && !cerrdefs.IsNotFound(resp.Error) {
+		// Docker could not say, so the container may well be fine.
+		w.DB[id].State = task.Unknown
+		w.mu.Unlock()
+		return
+	}
+	if resp.Container == nil {
+		logger.Error("no container for running task", "task_id", id)
+		w.clearDeadlineLocked(id)
+		w.DB[id].State = task.Failed
+		w.DB[id].FinishTime = time.Now().UTC()
+		w.DB[id].Status = task.Status{Error: "container not found", Reason: "container not found", FinishedAt: w.DB[id].FinishTime}
+		exited := *w.DB[id]
+		w.mu.Unlock()
+		w.stopSidecars(exited)
+		w.cleanupTask(id)
+		return
+	}
 
-		if resp.Container.State.Status == "exited" {
-			logger.Warn("container in non-running state", "task_id", id, "status", resp.Container.State.Status)
-			w.DB[id].State = task.Failed
+	if status := resp.Container.State.Status; status == "exited" || status == "dead" {
+		w.clearDeadlineLocked(id)
+		t := w.DB[id]
+		t.Status = task.NewStatus(resp.Container.State)
+		t.FinishTime = t.Status.FinishedAt
+		if t.FinishTime.IsZero() {
+			t.FinishTime = time.Now().UTC()
+		}
+		// A container killed for running too long fails even if it
+		// exited 0 on SIGTERM.
+		if !t.Status.Failed() && t.Reason != task.ReasonDeadlineExceeded {
+			logger.Info("container exited successfully", "task_id", id)
+			t.State = task.Completed
+		} else {
+			logger.Warn("container exited with error", "task_id", id, "reason", t.Status.Reason)
+			t.State = task.Failed
 		}
+	}
+	if w.DB[id].State == task.Unknown && resp.Container.State.Running {
+		w.DB[id].State = task.Running
+	}
 
-		w.DB[id].HostPorts = resp.Container.NetworkSettings.NetworkSettingsBase.Ports
-		w.mu.Unlock()
+	ports := resp.Container.NetworkSettings.NetworkSettingsBase.Ports
+	portsChanged := !maps.EqualFunc(w.DB[id].HostPorts, ports, slices.Equal)
+	w.DB[id].HostPorts = ports
+	exited := *w.DB[id]
+	w.mu.Unlock()
+
+	// Sidecars only live as long as the main container.
+	if exited.State == task.Completed || exited.State == task.Failed {
+		w.stopSidecars(exited)
+		w.cleanupTask(id)
+		return
+	}
+	// Ports Docker allocates are only known now. Restarting the
+	// container for them could allocate new ones, so they are only
+	// written out.
+	if portsChanged && len(exited.Templates) > 0 {
+		if err := w.rerenderTemplates(id, false); err != nil {
+			logger.Error("error rendering templates", "task_id", id, "err", err)
+		}
 	}
 }
 
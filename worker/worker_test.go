@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/ctfrancia/mongeta/task"
+	"github.com/google/uuid"
+	"github.com/moby/moby/api/types/container"
+)
+
+// inspected fakes inspecting a container in the given state.
+func inspected(s container.State) task.DockerInspectResponse {
+	return task.DockerInspectResponse{Container: &container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{State: &s},
+		NetworkSettings:   &container.NetworkSettings{},
+	}}
+}
+
+func TestUpdateTask(t *testing.T) {
+	const finished = "2026-01-02T03:04:05Z"
+	tests := []struct {
+		name       string
+		state      task.State
+		reason     string
+		resp       task.DockerInspectResponse
+		want       task.State
+		wantStatus string
+	}{
+		{"still running", task.Running, "", inspected(container.State{Status: "running", Running: true}), task.Running, ""},
+		{"running again", task.Unknown, "", inspected(container.State{Status: "running", Running: true}), task.Running, ""},
+		{"exited 0", task.Running, "", inspected(container.State{Status: "exited", FinishedAt: finished}), task.Completed, "exited successfully"},
+		{"exited 1", task.Running, "", inspected(container.State{Status: "exited", ExitCode: 1, FinishedAt: finished}), task.Failed, "exited with code 1"},
+		{"OOM killed", task.Running, "", inspected(container.State{Status: "exited", ExitCode: 137, OOMKilled: true}), task.Failed, "OOM killed (exit code 137)"},
+		{"dead", task.Running, "", inspected(container.State{Status: "dead", Error: "no space left"}), task.Failed, "container error: no space left"},
+		{"killed at deadline", task.Running, task.ReasonDeadlineExceeded, inspected(container.State{Status: "exited", FinishedAt: finished}), task.Failed, "exited successfully"},
+		{"container gone", task.Running, "", task.DockerInspectResponse{Error: cerrdefs.ErrNotFound}, task.Failed, "container not found"},
+		{"docker unreachable", task.Running, "", task.DockerInspectResponse{Error: errors.New("connection refused")}, task.Unknown, ""},
+		{"stopped meanwhile", task.Completed, "", inspected(container.State{Status: "exited", ExitCode: 1}), task.Completed, ""},
+	}
+	for _, tt := range tests {
+		w := NewWorker(1)
+		id := uuid.New()
+		w.DB[id] = &task.Task{ID: id, State: tt.state, Reason: tt.reason}
+
+		w.updateTask(id, tt.resp)
+
+		got := w.DB[id]
+		if got.State != tt.want {
+			t.Errorf("%s: state = %s, want %s", tt.name, got.State, tt.want)
+		}
+		if got.Status.Reason != tt.wantStatus {
+			t.Errorf("%s: status reason = %q, want %q", tt.name, got.Status.Reason, tt.wantStatus)
+		}
+		if done := got.State == task.Completed || got.State == task.Failed; done && tt.state != task.Completed && got.FinishTime.IsZero() {
+			t.Errorf("%s: finished without a finish time", tt.name)
+		}
+	}
+}